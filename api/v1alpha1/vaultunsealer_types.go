@@ -0,0 +1,283 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AuthMethod is the vault auth method used to read the unseal keys.
+// +kubebuilder:validation:Enum=userpass;kubernetes;approle;jwt;cert
+type AuthMethod string
+
+const (
+	// AuthMethodUserpass authenticates with username and password.
+	AuthMethodUserpass AuthMethod = "userpass"
+	// AuthMethodKubernetes authenticates with the service account token of the unsealer.
+	AuthMethodKubernetes AuthMethod = "kubernetes"
+	// AuthMethodAppRole authenticates with an AppRole role_id and secret_id.
+	AuthMethodAppRole AuthMethod = "approle"
+	// AuthMethodJWT authenticates with a service account token at a jwt auth mount.
+	AuthMethodJWT AuthMethod = "jwt"
+	// AuthMethodCert authenticates with a TLS client certificate.
+	AuthMethodCert AuthMethod = "cert"
+)
+
+// RootTokenPolicy defines what happens to the root token created by the initialization of a vault.
+// +kubebuilder:validation:Enum=Discard;Store
+type RootTokenPolicy string
+
+const (
+	// RootTokenDiscard revokes the root token as soon as the vault is unsealed.
+	RootTokenDiscard RootTokenPolicy = "Discard"
+	// RootTokenStore stores the root token with the unseal keys.
+	RootTokenStore RootTokenPolicy = "Store"
+)
+
+// UnsealOrderStrategy defines the order in which the sealed pods of a StatefulSet are unsealed.
+// +kubebuilder:validation:Enum=Parallel;Ordered;LeaderFirst
+type UnsealOrderStrategy string
+
+const (
+	// UnsealOrderParallel unseals every sealed pod independently.
+	UnsealOrderParallel UnsealOrderStrategy = "Parallel"
+	// UnsealOrderOrdered unseals the pods one at a time by ordinal, starting with ordinal 0.
+	UnsealOrderOrdered UnsealOrderStrategy = "Ordered"
+	// UnsealOrderLeaderFirst unseals the pod that was active last first, followed by the other pods by ordinal.
+	// The last active pod is recorded in the status. Before a pod was seen active, the pods are unsealed by ordinal.
+	UnsealOrderLeaderFirst UnsealOrderStrategy = "LeaderFirst"
+)
+
+// ConditionTypeReady is the condition type reporting whether the configuration is active.
+const ConditionTypeReady = "Ready"
+
+// VaultUnsealerSpec defines the desired state of VaultUnsealer.
+// +kubebuilder:validation:XValidation:rule="has(self.statefulSet) != has(self.external)",message="exactly one of statefulSet or external must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.init) || has(self.statefulSet)",message="init is only supported for statefulSet"
+// +kubebuilder:validation:XValidation:rule="!has(self.raftJoin) || has(self.statefulSet)",message="raftJoin is only supported for statefulSet"
+// +kubebuilder:validation:XValidation:rule="!has(self.unsealOrder) || has(self.statefulSet)",message="unsealOrder is only supported for statefulSet"
+type VaultUnsealerSpec struct {
+	// StatefulSet is the name of the vault StatefulSet in the namespace of the VaultUnsealer.
+	// +optional
+	StatefulSet string `json:"statefulSet,omitempty"`
+	// External configures vaults running outside the cluster.
+	// +optional
+	External *External `json:"external,omitempty"`
+	// KeySource defines where the unseal keys are read from.
+	KeySource KeySource `json:"keySource"`
+	// Auth defines how to authenticate against the vault holding the unseal keys.
+	// If not set, the auth method is derived from the keys of the key source secret.
+	// +optional
+	Auth *Auth `json:"auth,omitempty"`
+	// Interval is the seal check interval. Defaults to 20m for external vaults and 1m for the pods of a StatefulSet.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// TLS configures the connections to external vaults.
+	// +optional
+	TLS *TLS `json:"tls,omitempty"`
+	// Init enables the initialization of the first pod of a StatefulSet that is not initialized yet.
+	// +optional
+	Init *Init `json:"init,omitempty"`
+	// RaftJoin joins new pods of a StatefulSet with raft storage to the raft cluster of the active node.
+	// +optional
+	RaftJoin *RaftJoin `json:"raftJoin,omitempty"`
+	// UnsealOrder defines the order in which the sealed pods of a StatefulSet are unsealed.
+	// +optional
+	UnsealOrder *UnsealOrder `json:"unsealOrder,omitempty"`
+}
+
+// External defines the vaults to be unsealed outside the cluster.
+type External struct {
+	// Source is the address of the vault the unseal keys are read from.
+	// +kubebuilder:validation:MinLength=1
+	Source string `json:"source"`
+	// Targets are the addresses of the vaults to be unsealed.
+	// +kubebuilder:validation:MinItems=1
+	Targets []string `json:"targets"`
+}
+
+// KeySource defines where the unseal keys are read from.
+type KeySource struct {
+	// SecretRef references a Secret containing the unseal keys (unsealKey*)
+	// and/or the credentials and secret path used to read them from vault.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+	// VaultPath is the secret path within vault (<mount>/<path>) holding the unseal keys.
+	// Overrides the secretPath of the referenced Secret.
+	// +optional
+	VaultPath string `json:"vaultPath,omitempty"`
+	// Path is the path of a directory with files named unsealKey* or JSON documents with unsealKey* entries,
+	// or of a single JSON document. It must be mounted into the unsealer, e.g. with the Secrets Store CSI driver.
+	// The files are watched and the unseal keys are reloaded when they change.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// Auth defines the vault authentication.
+type Auth struct {
+	// Method is the vault auth method.
+	Method AuthMethod `json:"method"`
+	// Role is the role used for kubernetes or jwt auth, or the certificate role name used for cert auth.
+	// +optional
+	Role string `json:"role,omitempty"`
+	// MountPath is the mount path of the auth method.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+	// CredentialsSecretRef references a Secret with 'username' and 'password' used for userpass auth
+	// or with 'roleId' and 'secretId' used for approle auth
+	// or with 'clientCert' and 'clientKey' (or 'tls.crt' and 'tls.key') used for cert auth.
+	// Defaults to the key source secret.
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+	// Audience of the service account token used for kubernetes or jwt auth.
+	// If set, a short-lived token is requested through the TokenRequest API.
+	// +optional
+	Audience string `json:"audience,omitempty"`
+	// TokenExpiration is the lifetime of the requested service account token. Defaults to (and must be at least) 10m.
+	// +optional
+	TokenExpiration *metav1.Duration `json:"tokenExpiration,omitempty"`
+	// TokenPath is the path of a service account token file (e.g. a projected volume) used for kubernetes or jwt auth.
+	// +optional
+	TokenPath string `json:"tokenPath,omitempty"`
+}
+
+// Init defines the initialization of a vault, like 'vault operator init'.
+// +kubebuilder:validation:XValidation:rule="!has(self.shares) || !has(self.threshold) || self.threshold <= self.shares",message="threshold must not be greater than shares"
+type Init struct {
+	// Shares is the number of unseal key shares. Defaults to 5.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Shares int32 `json:"shares,omitempty"`
+	// Threshold is the number of shares required to unseal the vault. Defaults to 3.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Threshold int32 `json:"threshold,omitempty"`
+	// PGPKeys are base64 encoded PGP public keys, one per share, the shares are encrypted with.
+	// The unsealer needs the matching decryption key to unseal the vault.
+	// +optional
+	PGPKeys []string `json:"pgpKeys,omitempty"`
+	// SecretName is the name of the Secret the init output is stored in (key init.json).
+	// Defaults to the key source secret. The Secret is created if it does not exist,
+	// an existing Secret with unseal keys is never overwritten.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// Vault stores the init output in a kv secret of another vault, in addition to the Secret.
+	// +optional
+	Vault *InitVault `json:"vault,omitempty"`
+	// RootToken defines whether the root token is revoked after the unseal or stored with the init output.
+	// Defaults to Discard.
+	// +optional
+	RootToken RootTokenPolicy `json:"rootToken,omitempty"`
+}
+
+// InitVault defines a kv secret in another vault.
+type InitVault struct {
+	// Address is the address of the vault. The auth settings of the VaultUnsealer are used to log in.
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+	// Path is the secret path within vault (<mount>/<path>).
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+}
+
+// RaftJoin defines how uninitialized pods join the raft cluster of the active node, like 'vault operator raft join'.
+type RaftJoin struct {
+	// LeaderCASecretRef references a Secret key containing the PEM encoded CA certificate of the active node.
+	// If the reference is optional, no CA certificate is sent while the Secret or key does not exist.
+	// +optional
+	LeaderCASecretRef *corev1.SecretKeySelector `json:"leaderCASecretRef,omitempty"`
+	// LeaderTLSServerName is the TLS server name used to verify the certificate of the active node.
+	// +optional
+	LeaderTLSServerName string `json:"leaderTLSServerName,omitempty"`
+}
+
+// UnsealOrder defines the order in which the sealed pods of a StatefulSet are unsealed.
+type UnsealOrder struct {
+	// Strategy is the order strategy. The first pod is unsealed first, the other pods one at a time,
+	// once the first pod is active. Defaults to Parallel.
+	// +optional
+	Strategy UnsealOrderStrategy `json:"strategy,omitempty"`
+	// SettleDelay is the minimal delay between the unseal of two pods. Defaults to 10s.
+	// +optional
+	SettleDelay *metav1.Duration `json:"settleDelay,omitempty"`
+	// ActiveTimeout is the maximal time to wait for an unsealed pod to become active, before the next pod is unsealed.
+	// A raft cluster needs a quorum of unsealed pods to elect an active node. Defaults to 1m.
+	// +optional
+	ActiveTimeout *metav1.Duration `json:"activeTimeout,omitempty"`
+}
+
+// TLS defines the TLS settings of the vault clients.
+type TLS struct {
+	// InsecureSkipVerify disables the verification of the vault server certificate.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CASecretRef references a Secret key containing the PEM encoded CA certificate of the vault server.
+	// If the reference is optional, the system roots are used while the Secret or key does not exist.
+	// +optional
+	CASecretRef *corev1.SecretKeySelector `json:"caSecretRef,omitempty"`
+}
+
+// VaultUnsealerStatus defines the observed state of VaultUnsealer.
+type VaultUnsealerStatus struct {
+	// ObservedGeneration is the last generation that was reconciled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions represent the latest available observations of the VaultUnsealer.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastActivePod is the pod of the StatefulSet that was last seen as active node.
+	// It is unsealed first with the LeaderFirst strategy.
+	// +optional
+	LastActivePod string `json:"lastActivePod,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="StatefulSet",type=string,JSONPath=`.spec.statefulSet`
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.external.source`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// VaultUnsealer is the Schema for the vaultunsealers API.
+type VaultUnsealer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultUnsealerSpec   `json:"spec,omitempty"`
+	Status VaultUnsealerStatus `json:"status,omitempty"`
+}
+
+// SecretNames returns the names of all Secrets referenced by the VaultUnsealer.
+func (u *VaultUnsealer) SecretNames() []string {
+	var names []string
+	if u.Spec.KeySource.SecretRef != nil {
+		names = append(names, u.Spec.KeySource.SecretRef.Name)
+	}
+	if u.Spec.Auth != nil && u.Spec.Auth.CredentialsSecretRef != nil {
+		names = append(names, u.Spec.Auth.CredentialsSecretRef.Name)
+	}
+	if u.Spec.TLS != nil && u.Spec.TLS.CASecretRef != nil {
+		names = append(names, u.Spec.TLS.CASecretRef.Name)
+	}
+	if u.Spec.Init != nil && u.Spec.Init.SecretName != "" {
+		names = append(names, u.Spec.Init.SecretName)
+	}
+	if u.Spec.RaftJoin != nil && u.Spec.RaftJoin.LeaderCASecretRef != nil {
+		names = append(names, u.Spec.RaftJoin.LeaderCASecretRef.Name)
+	}
+	return names
+}
+
+// +kubebuilder:object:root=true
+
+// VaultUnsealerList contains a list of VaultUnsealer.
+type VaultUnsealerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VaultUnsealer `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VaultUnsealer{}, &VaultUnsealerList{})
+}
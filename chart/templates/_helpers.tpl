@@ -69,3 +69,10 @@ Create the name of the role to use
     {{ default "default" .Values.rbac.roleName }}
 {{- end -}}
 {{- end -}}
+
+{{/*
+Create the name of the cluster role to use. It contains the namespace, as cluster roles are not namespaced.
+*/}}
+{{- define "vault-unsealer.clusterRoleName" -}}
+{{- printf "%s-%s" (include "vault-unsealer.roleName" .) .Release.Namespace | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+)
+
+// retryBaseDelay is the delay before the first retry of a failed attempt. It is doubled with every consecutive
+// failure until the maximum delay is reached.
+var retryBaseDelay = 5 * time.Second
+
+// retryMaxDelay is the maximum delay before a failed attempt on a vault pod is retried.
+const retryMaxDelay = 5 * time.Minute
+
+// waitMaxDelay is the maximum delay before a pod waiting for its initialization or for unseal keys is checked again.
+const waitMaxDelay = time.Minute
+
+// retryJitter is the fraction of the delay that is randomized, to spread the retries of many targets.
+const retryJitter = 0.2
+
+var (
+	// errNotInitialized is the reason a pod waits for the initialization of its vault.
+	errNotInitialized = errors.New("vault is not initialized")
+	// errNoUnsealKeys is the reason a sealed pod waits for unseal keys.
+	errNoUnsealKeys = errors.New("no unseal keys available")
+)
+
+// retries tracks the consecutive failures of targets, to retry failed attempts with an exponential backoff.
+// Transient failures (e.g. an unreachable vault) are retried with the backoff, permanent failures
+// (e.g. rejected unseal keys or a missing policy) are retried after the maximum delay.
+type retries struct {
+	mux    sync.Mutex
+	states map[string]*retryState
+}
+
+// retryState is the retry state of a single target.
+type retryState struct {
+	failures int
+	next     time.Time
+}
+
+// failed records a failed attempt of the target and returns the delay before the next attempt.
+func (r *retries) failed(target string, err error, maxDelay time.Duration) time.Duration {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.states == nil {
+		r.states = make(map[string]*retryState)
+	}
+	st, ok := r.states[target]
+	if !ok {
+		st = &retryState{}
+		r.states[target] = st
+	}
+	st.failures++
+
+	delay := retryDelay(st.failures, maxDelay)
+	if isPermanent(err) {
+		delay = maxDelay
+	}
+	delay = jitter(delay)
+	st.next = time.Now().Add(delay)
+	return delay
+}
+
+// reset resets the retry state of the target, after a successful attempt or a configuration change.
+func (r *retries) reset(target string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.states, target)
+}
+
+// failures returns the number of consecutive failures of the target.
+func (r *retries) failures(target string) int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if st, ok := r.states[target]; ok {
+		return st.failures
+	}
+	return 0
+}
+
+// pending returns the remaining delay before the next attempt of the target.
+func (r *retries) pending(target string) time.Duration {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if st, ok := r.states[target]; ok {
+		return max(time.Until(st.next), 0)
+	}
+	return 0
+}
+
+// retryDelay returns the delay before the next retry after the given number of consecutive failures.
+func retryDelay(failures int, maxDelay time.Duration) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// jitter shortens the delay by a random fraction of up to retryJitter, so it never exceeds the maximum delay.
+func jitter(delay time.Duration) time.Duration {
+	return delay - time.Duration(rand.Float64()*retryJitter*float64(delay))
+}
+
+// permanentErrors are the errors that are not resolved by retrying soon.
+var permanentErrors = []error{errInsufficientKeys, errKeysRejected, errDecryptKeys}
+
+// isPermanent returns true if the error is not resolved by retrying soon: the vault refused the request
+// (e.g. a missing policy or invalid credentials), the unseal keys could not be decrypted or could not unseal the vault.
+// An error wrapping several errors is permanent if any of them is.
+func isPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, p := range permanentErrors {
+		if errors.Is(err, p) {
+			return true
+		}
+	}
+	var re *vault.ResponseError
+	if errors.As(err, &re) {
+		return re.StatusCode >= http.StatusBadRequest && re.StatusCode < http.StatusInternalServerError &&
+			re.StatusCode != http.StatusTooManyRequests && re.StatusCode != http.StatusRequestTimeout
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("retries", func() {
+	var (
+		sut       *retries
+		transient error
+		permanent error
+	)
+
+	BeforeEach(func() {
+		sut = &retries{}
+		transient = errors.New("connection refused")
+		permanent = fmt.Errorf("login error: %w", &vault.ResponseError{StatusCode: http.StatusForbidden})
+	})
+
+	It("should back off transient failures exponentially with jitter", func() {
+		for i, expected := range []time.Duration{retryBaseDelay, 2 * retryBaseDelay, 4 * retryBaseDelay} {
+			delay := sut.failed("default/vault-0", transient, time.Hour)
+			Ω(delay).Should(BeNumerically("<=", expected))
+			Ω(delay).Should(BeNumerically(">=", time.Duration(float64(expected)*(1-retryJitter))))
+			Ω(sut.failures("default/vault-0")).Should(Equal(i + 1))
+		}
+		Ω(sut.pending("default/vault-0")).Should(BeNumerically(">", 0))
+		Ω(sut.pending("default/vault-1")).Should(BeZero())
+	})
+
+	It("should not exceed the maximum delay", func() {
+		for range 20 {
+			Ω(sut.failed("default/vault-0", transient, time.Minute)).Should(BeNumerically("<=", time.Minute))
+		}
+	})
+
+	It("should retry permanent failures after the maximum delay", func() {
+		delay := sut.failed("default/vault-0", permanent, time.Minute)
+		Ω(delay).Should(BeNumerically(">=", time.Duration(float64(time.Minute)*(1-retryJitter))))
+	})
+
+	It("should reset the state of a target", func() {
+		sut.failed("default/vault-0", transient, time.Minute)
+		sut.reset("default/vault-0")
+		Ω(sut.failures("default/vault-0")).Should(BeZero())
+		Ω(sut.pending("default/vault-0")).Should(BeZero())
+	})
+
+	It("should classify the errors", func() {
+		Ω(isPermanent(transient)).Should(BeFalse())
+		Ω(isPermanent(permanent)).Should(BeTrue())
+		Ω(isPermanent(fmt.Errorf("%w: 1 keys available", errInsufficientKeys))).Should(BeTrue())
+		Ω(isPermanent(fmt.Errorf("%w with 3 keys", errKeysRejected))).Should(BeTrue())
+		Ω(isPermanent(&vault.ResponseError{StatusCode: http.StatusServiceUnavailable})).Should(BeFalse())
+		Ω(isPermanent(&vault.ResponseError{StatusCode: http.StatusTooManyRequests})).Should(BeFalse())
+		Ω(isPermanent(errors.Join(permanent, errKeysRejected))).Should(BeTrue())
+		Ω(isPermanent(errors.Join(transient, errKeysRejected))).Should(BeTrue())
+		Ω(isPermanent(errors.Join(transient, transient))).Should(BeFalse())
+		Ω(isPermanent(fmt.Errorf("%w", errDecryptKeys))).Should(BeTrue())
+		Ω(isPermanent(fmt.Errorf("%w: %w", errDecryptKeys, transient))).Should(BeTrue())
+		Ω(isPermanent(fmt.Errorf("%w: %w", transient, errKeysRejected))).Should(BeTrue())
+	})
+})
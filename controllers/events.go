@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// Event reasons and actions.
+const (
+	reasonSealed                 = "Sealed"
+	reasonUnsealed               = "Unsealed"
+	reasonUnsealFailed           = "UnsealFailed"
+	reasonInsufficientUnsealKeys = "InsufficientUnsealKeys"
+	reasonRejectedUnsealKeys     = "RejectedUnsealKeys"
+	reasonNotInitialized         = "NotInitialized"
+	reasonUnsealKeysLoaded       = "UnsealKeysLoaded"
+	reasonUnsealKeysNotLoaded    = "UnsealKeysNotLoaded"
+	reasonLoginFailed            = "LoginFailed"
+	reasonInitialized            = "Initialized"
+	reasonInitFailed             = "InitFailed"
+	reasonInitOutputNotStored    = "InitOutputNotStored"
+	reasonRaftJoined             = "RaftJoined"
+	reasonRaftJoinFailed         = "RaftJoinFailed"
+
+	actionCheck    = "CheckSealStatus"
+	actionUnseal   = "Unseal"
+	actionLoadKeys = "LoadUnsealKeys"
+	actionInit     = "Initialize"
+	actionRaftJoin = "RaftJoin"
+	actionApply    = "ApplyConfiguration"
+)
+
+// recordEvent records an event regarding the object, if a recorder is configured.
+func recordEvent(rec events.EventRecorder, obj runtime.Object, eventType, reason, action, note string) {
+	if rec == nil {
+		return
+	}
+	rec.Eventf(obj, nil, eventType, reason, action, "%s", note)
+}
+
+// recordUnsealResult records the events of an unseal with the given rejected shares and error.
+// The target is the address of an external vault, it is empty for pods.
+func recordUnsealResult(rec events.EventRecorder, obj runtime.Object, target string, rejected []int, err error) {
+	prefix := ""
+	if target != "" {
+		prefix = target + ": "
+	}
+	switch {
+	case errors.Is(err, errInsufficientKeys):
+		recordEvent(rec, obj, corev1.EventTypeWarning, reasonInsufficientUnsealKeys, actionUnseal, prefix+err.Error())
+	case err != nil:
+		recordEvent(rec, obj, corev1.EventTypeWarning, reasonUnsealFailed, actionUnseal, prefix+err.Error())
+	default:
+		recordEvent(rec, obj, corev1.EventTypeNormal, reasonUnsealed, actionUnseal, prefix+"the vault was unsealed")
+	}
+	if len(rejected) > 0 {
+		recordEvent(rec, obj, corev1.EventTypeWarning, reasonRejectedUnsealKeys, actionUnseal,
+			fmt.Sprintf("%sthe unseal key shares %v were rejected by the vault", prefix, rejected))
+	}
+}
+
+// recordKeysLoaded records the events of loading the unseal keys from vault with the given login and read errors.
+func recordKeysLoaded(rec events.EventRecorder, obj runtime.Object, keys int, loginErr, readErr error) {
+	switch {
+	case loginErr != nil:
+		recordEvent(rec, obj, corev1.EventTypeWarning, reasonLoginFailed, actionLoadKeys, loginErr.Error())
+	case readErr != nil:
+		recordEvent(rec, obj, corev1.EventTypeWarning, reasonUnsealKeysNotLoaded, actionLoadKeys, readErr.Error())
+	default:
+		recordEvent(rec, obj, corev1.EventTypeNormal, reasonUnsealKeysLoaded, actionLoadKeys,
+			fmt.Sprintf("loaded %d unseal keys from vault", keys))
+	}
+}
+
+// externalSecret returns a reference to the Secret configuring the external vault with the given key.
+func externalSecret(key types.VaultKey) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("events", func() {
+	var (
+		rec *events.FakeRecorder
+		pod *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		rec = events.NewFakeRecorder(10)
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "vault-0"}}
+	})
+
+	recorded := func() []string {
+		var e []string
+		for {
+			select {
+			case s := <-rec.Events:
+				e = append(e, s)
+			default:
+				return e
+			}
+		}
+	}
+
+	It("should record the unseal results", func() {
+		recordUnsealResult(rec, pod, "", nil, nil)
+		recordUnsealResult(rec, pod, "", []int{2}, fmt.Errorf("%w with 3 keys", errKeysRejected))
+		recordUnsealResult(rec, pod, "", nil, fmt.Errorf("%w: 1 keys available", errInsufficientKeys))
+		Ω(recorded()).Should(Equal([]string{
+			"Normal Unsealed the vault was unsealed",
+			"Warning UnsealFailed could not unseal the vault with 3 keys",
+			"Warning RejectedUnsealKeys the unseal key shares [2] were rejected by the vault",
+			"Warning InsufficientUnsealKeys insufficient unseal keys: 1 keys available",
+		}))
+	})
+
+	It("should record the target of external vaults", func() {
+		recordUnsealResult(rec, externalSecret(types.ExternalKey("default", "ext")), "https://vault-1:8200", nil, nil)
+		Ω(recorded()).Should(Equal([]string{"Normal Unsealed https://vault-1:8200: the vault was unsealed"}))
+	})
+
+	It("should record the loading of the unseal keys", func() {
+		recordKeysLoaded(rec, pod, 3, nil, nil)
+		recordKeysLoaded(rec, pod, 0, errors.New("permission denied"), nil)
+		recordKeysLoaded(rec, pod, 0, nil, errors.New("secret not found"))
+		Ω(recorded()).Should(Equal([]string{
+			"Normal UnsealKeysLoaded loaded 3 unseal keys from vault",
+			"Warning LoginFailed permission denied",
+			"Warning UnsealKeysNotLoaded secret not found",
+		}))
+	})
+
+	It("should not interpret the note as format", func() {
+		recordEvent(rec, pod, corev1.EventTypeWarning, reasonUnsealFailed, actionUnseal, "100% sealed")
+		Ω(recorded()).Should(Equal([]string{"Warning UnsealFailed 100% sealed"}))
+	})
+})
@@ -3,145 +3,459 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/vault-client-go"
-	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
 	"github.com/bakito/vault-unsealer/pkg/cache"
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
 // ExternalHandler handles external vaults.
 type ExternalHandler struct {
 	client.Client
-	Scheme     *runtime.Scheme
-	startedMux sync.Mutex
-	started    bool
-	secrets    []corev1.Secret
-	Cache      cache.Cache
+	Scheme      *runtime.Scheme
+	startedMux  sync.Mutex
+	started     bool
+	Cache       cache.Cache
+	Tokens      *ServiceAccountTokens
+	VaultTokens *VaultTokens
+	Recorder    events.EventRecorder
+	// Decrypter decrypts the unseal keys of the external Secrets, if they are encrypted.
+	Decrypter keysource.Decrypter
+	// Health tracks the progress of the running checks, to detect wedged checks.
+	Health *Health
+
+	configsMux sync.Mutex
+	configs    map[types.VaultKey]*externalConfig
+	loops      map[types.VaultKey]*externalLoop
+	changed    chan struct{}
+	// events triggers the reconciliation of the VaultUnsealers whose check status changed.
+	events chan event.GenericEvent
+	// retries tracks the failed checks of the loops.
+	retries retries
+}
+
+// externalConfig is the configuration of a single external vault check loop.
+type externalConfig struct {
+	// version identifies the revision of the configuration. A running loop is only restarted if it changes.
+	version  string
+	interval time.Duration
+	source   *vault.Client
+	targets  []*vault.Client
+	// owner is the VaultUnsealer the check status is reported to, empty for external Secrets.
+	owner client.ObjectKey
+}
+
+// externalLoop is a running external vault check loop.
+type externalLoop struct {
+	version string
+	owner   client.ObjectKey
+	cancel  context.CancelFunc
+	// stopped is true once the loop has exited.
+	stopped atomic.Bool
+
+	statusMux sync.Mutex
+	status    externalStatus
+}
+
+// externalStatus reports the state of an external vault check loop.
+type externalStatus struct {
+	// Failures is the number of consecutive failed checks.
+	Failures int
+	// LastError is the error of the last failed check.
+	LastError string
+	// LastCheck is the time of the last check.
+	LastCheck time.Time
+}
+
+// setStatus records the result of a check and returns the updated status. changed is true for the first check
+// of the loop and if the check started or stopped failing.
+func (l *externalLoop) setStatus(err error) (st externalStatus, changed bool) {
+	l.statusMux.Lock()
+	defer l.statusMux.Unlock()
+	changed = l.status.LastCheck.IsZero() || (l.status.Failures == 0) != (err == nil)
+	l.status.LastCheck = time.Now()
+	if err != nil {
+		l.status.Failures++
+		l.status.LastError = err.Error()
+	} else {
+		l.status.Failures = 0
+		l.status.LastError = ""
+	}
+	return l.status, changed
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *ExternalHandler) SetupWithManager(mgr ctrl.Manager, secretsExternal []corev1.Secret) error {
-	r.secrets = secretsExternal
+func (r *ExternalHandler) SetupWithManager(mgr ctrl.Manager) error {
 	return mgr.Add(r)
 }
 
 func (r *ExternalHandler) Start(ctx context.Context) error {
 	r.startedMux.Lock()
 	if r.started {
+		r.startedMux.Unlock()
 		return errors.New("handler is already running")
 	}
 	r.started = true
 	r.startedMux.Unlock()
 
-	grp, ctx := errgroup.WithContext(ctx)
+	var wg sync.WaitGroup
+	changed := r.changedChan()
+	for {
+		r.syncLoops(ctx, &wg)
 
-	for _, s := range r.secrets {
-		grp.Go(func() error {
-			err := r.setupVaultCheckLoop(ctx, s)
-			if err != nil {
-				return err
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			r.configsMux.Lock()
+			for _, l := range r.loops {
+				l.cancel()
 			}
-			return context.Canceled
+			r.loops = nil
+			r.configsMux.Unlock()
+			wg.Wait()
+			return nil
+		}
+	}
+}
+
+// syncLoops starts, restarts and stops the check loops to match the current configurations.
+// Every loop is supervised independently, a failing loop does not affect the others.
+func (r *ExternalHandler) syncLoops(ctx context.Context, wg *sync.WaitGroup) {
+	r.configsMux.Lock()
+	defer r.configsMux.Unlock()
+
+	if r.loops == nil {
+		r.loops = make(map[types.VaultKey]*externalLoop)
+	}
+
+	for key, l := range r.loops {
+		if cfg, ok := r.configs[key]; !ok || cfg.version != l.version {
+			l.cancel()
+			delete(r.loops, key)
+		}
+	}
+
+	for key, cfg := range r.configs {
+		if _, ok := r.loops[key]; ok {
+			continue
+		}
+		loopCtx, cancel := context.WithCancel(ctx)
+		loop := &externalLoop{version: cfg.version, owner: cfg.owner, cancel: cancel}
+		r.loops[key] = loop
+		r.retries.reset(key.String())
+		wg.Go(func() {
+			defer loop.stopped.Store(true)
+			r.supervise(loopCtx, key, loop, cfg.interval, func(ctx context.Context) error {
+				return r.handleExternal(ctx, key, cfg.source, cfg.targets)
+			})
 		})
 	}
+}
 
-	_ = grp.Wait()
+// running returns an error if the check loop of a configured external vault is not running.
+func (r *ExternalHandler) running() error {
+	r.startedMux.Lock()
+	started := r.started
+	r.startedMux.Unlock()
+
+	r.configsMux.Lock()
+	defer r.configsMux.Unlock()
+	if len(r.configs) == 0 {
+		return nil
+	}
+	if !started {
+		return errors.New("external handler is not running")
+	}
+	var stopped []string
+	for key, cfg := range r.configs {
+		if l, ok := r.loops[key]; !ok || l.version != cfg.version || l.stopped.Load() {
+			stopped = append(stopped, key.String())
+		}
+	}
+	if len(stopped) > 0 {
+		slices.Sort(stopped)
+		return fmt.Errorf("external check loops are not running: %s", strings.Join(stopped, ", "))
+	}
 	return nil
 }
 
-func (r *ExternalHandler) setupVaultCheckLoop(ctx context.Context, secret corev1.Secret) error {
-	if r.Cache.VaultInfoFor(secret.Name) == nil {
-		v := extractVaultInfo(secret)
-		r.Cache.SetVaultInfoFor(secret.Name, v)
+// status returns the status of the check loop with the given key.
+func (r *ExternalHandler) status(key types.VaultKey) (externalStatus, bool) {
+	r.configsMux.Lock()
+	l, ok := r.loops[key]
+	r.configsMux.Unlock()
+	if !ok {
+		return externalStatus{}, false
+	}
+	l.statusMux.Lock()
+	defer l.statusMux.Unlock()
+	return l.status, true
+}
+
+// apply adds or updates the configuration of the check loop with the given key.
+func (r *ExternalHandler) apply(key types.VaultKey, cfg *externalConfig) {
+	r.configsMux.Lock()
+	if r.configs == nil {
+		r.configs = make(map[types.VaultKey]*externalConfig)
+	}
+	current, ok := r.configs[key]
+	if ok && current.version == cfg.version {
+		r.configsMux.Unlock()
+		return
+	}
+	r.configs[key] = cfg
+	r.dropSealed(current)
+	r.configsMux.Unlock()
+	r.notify()
+}
+
+// remove stops the check loop with the given key if its configuration has the given version.
+func (r *ExternalHandler) remove(key types.VaultKey, version string) {
+	r.configsMux.Lock()
+	cfg, ok := r.configs[key]
+	if !ok || cfg.version != version {
+		r.configsMux.Unlock()
+		return
+	}
+	delete(r.configs, key)
+	r.dropSealed(cfg)
+	r.configsMux.Unlock()
+	r.notify()
+}
+
+// dropSealed removes the sealed metric of the targets of a replaced or removed configuration, that are not checked
+// by another configuration. The caller must hold configsMux.
+func (r *ExternalHandler) dropSealed(cfg *externalConfig) {
+	if cfg == nil {
+		return
+	}
+	checked := make(map[string]bool)
+	for _, c := range r.configs {
+		for _, t := range c.targets {
+			checked[t.Configuration().Address] = true
+		}
 	}
+	for _, t := range cfg.targets {
+		if addr := t.Configuration().Address; !checked[addr] {
+			metrics.Sealed.DeleteLabelValues(addr)
+		}
+	}
+}
 
-	duration := r.getInterval(ctx, secret)
+// notify signals a configuration change to the running handler.
+func (r *ExternalHandler) notify() {
+	select {
+	case r.changedChan() <- struct{}{}:
+	default:
+	}
+}
 
-	srcCl, err := r.getSourceClient(secret)
+func (r *ExternalHandler) changedChan() chan struct{} {
+	r.configsMux.Lock()
+	defer r.configsMux.Unlock()
+	if r.changed == nil {
+		r.changed = make(chan struct{}, 1)
+	}
+	return r.changed
+}
+
+// applySecret registers the check loop configured by the given external Secret and returns the cached VaultInfo.
+func (r *ExternalHandler) applySecret(ctx context.Context, secret corev1.Secret) (*types.VaultInfo, error) {
+	vi, err := extractVaultInfo(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptUnsealKeys(r.Decrypter, vi); err != nil {
+		return nil, err
+	}
+	srcCl, err := r.getSourceClient(secret, vi)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	trgtsCl, err := r.getTargetClients(secret)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// initial handle cycle
-	r.handleExternal(ctx, secret.Name, srcCl, trgtsCl)
+	key := types.ExternalKey(secret.Namespace, secret.Name)
+	setVaultInfo(r.Cache, key, vi)
 
-	// start the loop
-	t := time.NewTicker(duration).C
+	r.apply(key, &externalConfig{
+		version:  secret.ResourceVersion,
+		interval: r.getInterval(ctx, secret),
+		source:   srcCl,
+		targets:  trgtsCl,
+	})
+	return vi, nil
+}
+
+// supervise runs the check until the context is done. A failed check is retried with an exponential backoff
+// capped at the interval, a panicking check is recovered and handled as failure.
+func (r *ExternalHandler) supervise(
+	ctx context.Context,
+	key types.VaultKey,
+	loop *externalLoop,
+	interval time.Duration,
+	check func(ctx context.Context) error,
+) {
+	l := log.FromContext(ctx).WithValues("vault", key.String())
 	for {
+		delay := interval
+		checkCtx, done := r.Health.begin(ctx, "external vault "+key.String())
+		err := safeCheck(checkCtx, check)
+		done()
+		st, changed := loop.setStatus(err)
+		if err != nil {
+			delay = r.retries.failed(key.String(), err, interval)
+			l.WithValues("failures", st.Failures, "retry-in", delay.String()).
+				Error(err, "external vault check failed")
+		} else {
+			r.retries.reset(key.String())
+		}
+		if changed {
+			r.enqueueOwner(ctx, loop.owner)
+		}
+
+		t := time.NewTimer(delay)
 		select {
-		case <-t:
-			r.handleExternal(ctx, secret.Name, srcCl, trgtsCl)
+		case <-t.C:
 		case <-ctx.Done():
-			return nil
+			t.Stop()
+			return
 		}
 	}
 }
 
+// enqueueOwner triggers the reconciliation of the VaultUnsealer of a check loop, to report the changed check status.
+func (r *ExternalHandler) enqueueOwner(ctx context.Context, owner client.ObjectKey) {
+	r.configsMux.Lock()
+	events := r.events
+	r.configsMux.Unlock()
+	if events == nil || owner.Name == "" {
+		return
+	}
+	vu := &v1alpha1.VaultUnsealer{ObjectMeta: metav1.ObjectMeta{Namespace: owner.Namespace, Name: owner.Name}}
+	select {
+	case events <- event.GenericEvent{Object: vu}:
+	case <-ctx.Done():
+	}
+}
+
+// eventsChan returns the channel the reconcile events of the VaultUnsealers with a changed check status are sent to.
+func (r *ExternalHandler) eventsChan() chan event.GenericEvent {
+	r.configsMux.Lock()
+	defer r.configsMux.Unlock()
+	if r.events == nil {
+		r.events = make(chan event.GenericEvent)
+	}
+	return r.events
+}
+
+// safeCheck runs the check and converts a panic into an error.
+func safeCheck(ctx context.Context, check func(ctx context.Context) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("check panicked: %v", p)
+		}
+	}()
+	return check(ctx)
+}
+
 func (r *ExternalHandler) handleExternal(
 	ctx context.Context,
-	name string,
+	key types.VaultKey,
 	srcCl *vault.Client,
 	trgtCl []*vault.Client,
-) {
-	l := log.FromContext(ctx).WithValues("secret", name)
+) error {
+	l := log.FromContext(ctx).WithValues("vault", key.String())
 
-	vi := r.Cache.VaultInfoFor(name)
-	if vi == nil || len(vi.UnsealKeys) == 0 {
+	cached := r.Cache.VaultInfoFor(key)
+	if cached == nil {
+		return errors.New("no vault info found")
+	}
+	// the cached VaultInfo is shared, the keys are read into a copy
+	vi := cached.Clone()
+	if len(vi.UnsealKeys) == 0 {
 		l.Info("no unseal info found, starting lookup")
 
-		err := login(ctx, srcCl, vi)
-		if err != nil {
-			l.Error(err, "login error")
-			return
+		if err := r.VaultTokens.login(ctx, srcCl, key, vi, r.Tokens); err != nil {
+			recordKeysLoaded(r.Recorder, externalSecret(key), 0, err, nil)
+			return fmt.Errorf("login error: %w", err)
 		}
 
-		if err = readUnsealKeys(ctx, srcCl, vi); err != nil {
-			l.Error(err, "error reading unseal keys")
-			return
+		err := readUnsealKeys(ctx, srcCl, vi)
+		r.VaultTokens.release(ctx, srcCl)
+		recordKeysLoaded(r.Recorder, externalSecret(key), len(vi.UnsealKeys), nil, err)
+		if err != nil {
+			return fmt.Errorf("error reading unseal keys: %w", err)
 		}
 
-		r.Cache.SetVaultInfoFor(name, vi)
+		r.Cache.SetVaultInfoFor(key, vi)
 		l.WithValues("keys", len(vi.UnsealKeys)).Info("successfully read unseal keys from vault")
 	}
 
+	var errs []error
+	var keys []string
 	for _, cl := range trgtCl {
 		l.Info("checking seal status")
 
 		st, err := cl.System.SealStatus(ctx)
 		if err != nil {
-			l.Error(err, "error checking seal status")
+			errs = append(errs, fmt.Errorf("error checking seal status: %w", err))
 			continue
 		}
 
+		target := cl.Configuration().Address
+		metrics.Sealed.WithLabelValues(target).Set(metrics.Bool(st.Data.Sealed))
 		if !st.Data.Initialized {
 			l.Info("vault is not initialized")
+			recordEvent(r.Recorder, externalSecret(key), corev1.EventTypeWarning, reasonNotInitialized, actionCheck,
+				fmt.Sprintf("the vault %s is not initialized", target))
 			continue
 		}
 
 		if st.Data.Sealed {
 			l.Info("vault is sealed, starting unseal")
-			if err := unseal(ctx, cl, vi); err != nil {
-				l.Error(err, "error unsealing vault")
+			recordEvent(r.Recorder, externalSecret(key), corev1.EventTypeWarning, reasonSealed, actionCheck,
+				fmt.Sprintf("the vault %s is sealed, starting unseal", target))
+			if keys == nil {
+				if keys, err = unsealKeysFor(ctx, vi, r.Tokens); err != nil {
+					err = fmt.Errorf("%w: %w", errDecryptKeys, err)
+					observeUnseal(target, err)
+					recordUnsealResult(r.Recorder, externalSecret(key), target, nil, err)
+					return errors.Join(append(errs, err)...)
+				}
+			}
+			rejected, err := unseal(ctx, cl, target, &st.Data, keys)
+			recordUnsealResult(r.Recorder, externalSecret(key), target, rejected, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error unsealing vault: %w", err))
 			} else {
 				l.Info("successfully unsealed vault")
 			}
 		}
 	}
+	return errors.Join(errs...)
 }
 
 func (*ExternalHandler) getInterval(ctx context.Context, secret corev1.Secret) time.Duration {
@@ -155,13 +469,13 @@ func (*ExternalHandler) getInterval(ctx context.Context, secret corev1.Secret) t
 	return duration
 }
 
-func (*ExternalHandler) getSourceClient(secret corev1.Secret) (*vault.Client, error) {
+func (*ExternalHandler) getSourceClient(secret corev1.Secret, vi *types.VaultInfo) (*vault.Client, error) {
 	src, ok := secret.Annotations[constants.AnnotationExternalSource]
 	if !ok {
 		return nil, errors.New("no source found")
 	}
 
-	return newClient(src, false)
+	return newClient(src, false, vi)
 }
 
 func (*ExternalHandler) getTargetClients(secret corev1.Secret) ([]*vault.Client, error) {
@@ -174,7 +488,7 @@ func (*ExternalHandler) getTargetClients(secret corev1.Secret) ([]*vault.Client,
 	var trgtsCl []*vault.Client
 
 	for _, t := range trgts {
-		tcl, err := newClient(t, false)
+		tcl, err := newClient(t, false, nil)
 		if err != nil {
 			return nil, err
 		}
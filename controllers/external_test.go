@@ -2,12 +2,19 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/vault-client-go"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	"github.com/bakito/vault-unsealer/pkg/cache"
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 	"github.com/bakito/vault-unsealer/pkg/types"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -39,7 +46,7 @@ var _ = Describe("PodReconciler", func() {
 			},
 		}
 
-		sut.Cache.SetVaultInfoFor(secret.Name, &types.VaultInfo{})
+		sut.Cache.SetVaultInfoFor(types.ExternalKey(secret.Namespace, secret.Name), &types.VaultInfo{})
 	})
 
 	It("should return correct interval", func() {
@@ -48,7 +55,7 @@ var _ = Describe("PodReconciler", func() {
 	})
 
 	It("should return source client", func() {
-		c, err := sut.getSourceClient(*secret)
+		c, err := sut.getSourceClient(*secret, nil)
 		Expect(err).To(BeNil())
 		Expect(c).NotTo(BeNil())
 	})
@@ -58,4 +65,144 @@ var _ = Describe("PodReconciler", func() {
 		Expect(err).To(BeNil())
 		Expect(len(c)).To(Equal(2))
 	})
+
+	Context("retryDelay", func() {
+		It("should double the delay up to the interval", func() {
+			Expect(retryDelay(1, time.Minute)).To(Equal(retryBaseDelay))
+			Expect(retryDelay(2, time.Minute)).To(Equal(2 * retryBaseDelay))
+			Expect(retryDelay(3, time.Minute)).To(Equal(4 * retryBaseDelay))
+			Expect(retryDelay(100, time.Minute)).To(Equal(time.Minute))
+		})
+	})
+
+	Context("supervise", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			loop   *externalLoop
+			orig   time.Duration
+			done   chan struct{}
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.TODO())
+			loop = &externalLoop{}
+			orig = retryBaseDelay
+			retryBaseDelay = time.Millisecond
+			done = make(chan struct{})
+		})
+
+		AfterEach(func() {
+			cancel()
+			Eventually(done).Should(BeClosed())
+			retryBaseDelay = orig
+		})
+
+		supervise := func(check func(ctx context.Context) error) {
+			go func() {
+				defer close(done)
+				sut.supervise(ctx, types.ExternalKey("default", "test"), loop, time.Hour, check)
+			}()
+		}
+
+		It("should retry a failing check and report the failures", func() {
+			var calls atomic.Int32
+			supervise(func(context.Context) error {
+				if calls.Add(1) < 3 {
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			Eventually(calls.Load).Should(BeNumerically(">=", 3))
+			Eventually(func() int {
+				loop.statusMux.Lock()
+				defer loop.statusMux.Unlock()
+				return loop.status.Failures
+			}).Should(Equal(0))
+		})
+
+		It("should reconcile the owner once the check status changes", func() {
+			loop.owner = client.ObjectKey{Namespace: "default", Name: "vault"}
+			events := sut.eventsChan()
+			var calls atomic.Int32
+			supervise(func(context.Context) error {
+				if calls.Add(1) == 1 {
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			// the first check failed and the retry recovered
+			for range 2 {
+				var e event.GenericEvent
+				Eventually(events).Should(Receive(&e))
+				Expect(client.ObjectKeyFromObject(e.Object)).To(Equal(loop.owner))
+			}
+			Consistently(events, "50ms").ShouldNot(Receive())
+		})
+
+		It("should recover a panicking check", func() {
+			var calls atomic.Int32
+			supervise(func(context.Context) error {
+				calls.Add(1)
+				panic("boom")
+			})
+
+			Eventually(calls.Load).Should(BeNumerically(">=", 2))
+			loop.statusMux.Lock()
+			defer loop.statusMux.Unlock()
+			Expect(loop.status.LastError).To(ContainSubstring("boom"))
+		})
+	})
+
+	Context("Start", func() {
+		It("should start and stop the loops with their configuration", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = sut.Start(ctx)
+			}()
+
+			a := types.ExternalKey("default", "a")
+			b := types.ExternalKey("default", "b")
+			sut.apply(a, &externalConfig{version: "1", interval: time.Hour})
+			sut.apply(b, &externalConfig{version: "1", interval: time.Hour})
+			Eventually(func() bool {
+				_, okA := sut.status(a)
+				_, okB := sut.status(b)
+				return okA && okB
+			}).Should(BeTrue())
+
+			sut.remove(a, "1")
+			Eventually(func() bool {
+				_, ok := sut.status(a)
+				return ok
+			}).Should(BeFalse())
+			_, ok := sut.status(b)
+			Expect(ok).To(BeTrue())
+
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("Sealed metric", func() {
+		It("should remove the metric of the targets that are no longer checked", func() {
+			shared, err := newClient("https://vault-1:8200", true, nil)
+			Expect(err).NotTo(HaveOccurred())
+			dropped, err := newClient("https://vault-2:8200", true, nil)
+			Expect(err).NotTo(HaveOccurred())
+			a := types.ExternalKey("default", "a")
+			sut.apply(a, &externalConfig{version: "1", targets: []*vault.Client{shared, dropped}})
+			sut.apply(types.ExternalKey("default", "b"), &externalConfig{version: "1", targets: []*vault.Client{shared}})
+			metrics.Sealed.WithLabelValues("https://vault-1:8200").Set(1)
+			metrics.Sealed.WithLabelValues("https://vault-2:8200").Set(1)
+
+			sut.remove(a, "1")
+			Expect(metrics.Sealed.DeleteLabelValues("https://vault-2:8200")).To(BeFalse())
+			Expect(metrics.Sealed.DeleteLabelValues("https://vault-1:8200")).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+)
+
+// configListRetryDelay is the delay before the configurations are listed again after a failure.
+var configListRetryDelay = 10 * time.Second
+
+const (
+	configKindVaultUnsealer = "VaultUnsealer"
+	configKindSecret        = "Secret"
+)
+
+// Health reports the readiness and liveness of the unsealer.
+// The unsealer is ready once the shared cache is ready, every configuration was loaded and the check loops of all
+// external vaults are running. Errors of single configurations are reported by the status of the VaultUnsealer or
+// events of the Secret and do not affect the readiness. The configurations are only checked on the elected leader,
+// as the controllers do not run on the other instances.
+// The unsealer is not alive if a reconcile or an external check makes no progress within the liveness timeout.
+type Health struct {
+	client.Reader
+	Cache    cache.Cache
+	External *ExternalHandler
+	// DisableSecrets disables the loading of the labeled Secrets, in line with the Secret controller.
+	DisableSecrets bool
+	// LivenessTimeout is the duration after which a reconcile or external check without progress is considered
+	// wedged. Defaults to 10m.
+	LivenessTimeout time.Duration
+
+	elected atomic.Bool
+
+	mux    sync.Mutex
+	loaded bool
+	// configs tracks whether the configurations that existed on startup were reconciled.
+	configs map[string]bool
+	tasks   map[uint64]*task
+	nextID  uint64
+}
+
+// task is a running reconcile or external check.
+type task struct {
+	name     string
+	progress time.Time
+}
+
+// progressKey is the context key of the progress function of a task.
+type progressKey struct{}
+
+// SetupWithManager sets up the health checks with the Manager.
+func (h *Health) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(h)
+}
+
+// Start registers the configurations that exist once the instance is elected leader,
+// so readiness waits until each of them is loaded. Failures to list the configurations are retried.
+func (h *Health) Start(ctx context.Context) error {
+	h.elected.Store(true)
+
+	l := log.FromContext(ctx)
+	_ = wait.PollUntilContextCancel(ctx, configListRetryDelay, true, func(ctx context.Context) (bool, error) {
+		names, err := h.listConfigs(ctx)
+		if err != nil {
+			l.WithValues("retry-in", configListRetryDelay.String()).Error(err, "could not list the configurations")
+			return false, nil
+		}
+		h.expect(names)
+		return true, nil
+	})
+	return nil
+}
+
+// expect registers the configurations with the given names, that were not yet reconciled.
+func (h *Health) expect(names []string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.configs == nil {
+		h.configs = make(map[string]bool)
+	}
+	for _, name := range names {
+		if _, ok := h.configs[name]; !ok {
+			h.configs[name] = false
+		}
+	}
+	h.loaded = true
+}
+
+// listConfigs returns the names of the VaultUnsealers and the labeled Secrets.
+func (h *Health) listConfigs(ctx context.Context) ([]string, error) {
+	vus := &v1alpha1.VaultUnsealerList{}
+	if err := h.List(ctx, vus); err != nil {
+		return nil, fmt.Errorf("could not list vault unsealers: %w", err)
+	}
+	var names []string
+	for i := range vus.Items {
+		names = append(names, configName(configKindVaultUnsealer, client.ObjectKeyFromObject(&vus.Items[i])))
+	}
+	if h.DisableSecrets {
+		return names, nil
+	}
+
+	for _, label := range []string{constants.LabelStatefulSetName, constants.LabelExternal} {
+		secrets := &corev1.SecretList{}
+		if err := h.List(ctx, secrets, client.HasLabels{label}); err != nil {
+			return nil, fmt.Errorf("could not list unseal secrets: %w", err)
+		}
+		for i := range secrets.Items {
+			names = append(names, configName(configKindSecret, client.ObjectKeyFromObject(&secrets.Items[i])))
+		}
+	}
+	return names, nil
+}
+
+// reconciled records that the configuration of the object was reconciled, independent of its result.
+func (h *Health) reconciled(kind string, key client.ObjectKey) {
+	if h == nil {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.configs == nil {
+		h.configs = make(map[string]bool)
+	}
+	h.configs[configName(kind, key)] = true
+}
+
+// forget removes the configuration of the object, once it is deleted or no longer handled by the reconciler.
+func (h *Health) forget(kind string, key client.ObjectKey) {
+	if h == nil {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	delete(h.configs, configName(kind, key))
+}
+
+// begin registers a running reconcile or external check with the given name. Every vault request made with the
+// returned context reports progress of the task. The returned function must be called once the task is finished.
+func (h *Health) begin(ctx context.Context, name string) (context.Context, func()) {
+	if h == nil {
+		return ctx, func() {}
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.tasks == nil {
+		h.tasks = make(map[uint64]*task)
+	}
+	h.nextID++
+	id := h.nextID
+	t := &task{name: name, progress: time.Now()}
+	h.tasks[id] = t
+
+	progress := func() {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		t.progress = time.Now()
+	}
+	return context.WithValue(ctx, progressKey{}, progress), func() {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		delete(h.tasks, id)
+	}
+}
+
+// reportProgress reports progress of the task the context belongs to, if any.
+func reportProgress(ctx context.Context) {
+	if progress, ok := ctx.Value(progressKey{}).(func()); ok {
+		progress()
+	}
+}
+
+// Readyz is the readiness check of the unsealer.
+func (h *Health) Readyz(_ *http.Request) error {
+	if err := h.Cache.Ready(); err != nil {
+		return fmt.Errorf("shared cache: %w", err)
+	}
+	if !h.elected.Load() {
+		return nil
+	}
+
+	h.mux.Lock()
+	loaded := h.loaded
+	var pending []string
+	for name, reconciled := range h.configs {
+		if !reconciled {
+			pending = append(pending, name)
+		}
+	}
+	h.mux.Unlock()
+
+	if !loaded {
+		return errors.New("configuration is not loaded yet")
+	}
+	if len(pending) > 0 {
+		slices.Sort(pending)
+		return fmt.Errorf("configuration is not loaded yet: %s", strings.Join(pending, ", "))
+	}
+	if h.External != nil {
+		return h.External.running()
+	}
+	return nil
+}
+
+// Livez is the liveness check of the unsealer. It fails if a reconcile or an external check made no progress
+// within the liveness timeout.
+func (h *Health) Livez(_ *http.Request) error {
+	timeout := h.LivenessTimeout
+	if timeout <= 0 {
+		timeout = constants.DefaultLivenessTimeout
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	var wedged []string
+	for _, t := range h.tasks {
+		if d := time.Since(t.progress); d > timeout {
+			wedged = append(wedged, fmt.Sprintf("%s (no progress for %s)", t.name, d.Truncate(time.Second)))
+		}
+	}
+	if len(wedged) > 0 {
+		slices.Sort(wedged)
+		return fmt.Errorf("wedged: %s", strings.Join(wedged, ", "))
+	}
+	return nil
+}
+
+// configName returns the name of the configuration of the object.
+func configName(kind string, key client.ObjectKey) string {
+	return fmt.Sprintf("%s %s", kind, key)
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// failingReader fails to list objects the given number of times.
+type failingReader struct {
+	client.Reader
+	failures int
+}
+
+func (r *failingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("the server is currently unable to handle the request")
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+var _ = Describe("Health", func() {
+	var (
+		ctx    context.Context
+		sut    *Health
+		vu     client.ObjectKey
+		secret client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		vu = client.ObjectKey{Namespace: "default", Name: "vault"}
+		secret = client.ObjectKey{Namespace: "default", Name: "unseal"}
+
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		Ω(v1alpha1.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sut = &Health{
+			Reader: fake.NewClientBuilder().WithScheme(s).WithObjects(
+				&v1alpha1.VaultUnsealer{ObjectMeta: metav1.ObjectMeta{Namespace: vu.Namespace, Name: vu.Name}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+					Namespace: secret.Namespace,
+					Name:      secret.Name,
+					Labels:    map[string]string{constants.LabelStatefulSetName: "vault"},
+				}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"}},
+			).Build(),
+			Cache:    cache.NewSimple(false),
+			External: &ExternalHandler{},
+		}
+	})
+
+	It("should be ready before it is elected", func() {
+		Ω(sut.Readyz(nil)).Should(Succeed())
+	})
+
+	It("should be ready once the configurations are loaded", func() {
+		Ω(sut.Start(ctx)).Should(Succeed())
+		Ω(sut.Readyz(nil)).Should(MatchError(
+			"configuration is not loaded yet: Secret default/unseal, VaultUnsealer default/vault"))
+
+		sut.reconciled(configKindVaultUnsealer, vu)
+		Ω(sut.Readyz(nil)).Should(MatchError("configuration is not loaded yet: Secret default/unseal"))
+
+		sut.forget(configKindSecret, secret)
+		Ω(sut.Readyz(nil)).Should(Succeed())
+	})
+
+	It("should retry listing the configurations", func() {
+		configListRetryDelay = 10 * time.Millisecond
+		DeferCleanup(func() { configListRetryDelay = 10 * time.Second })
+		sut.Reader = &failingReader{Reader: sut.Reader, failures: 2}
+
+		Ω(sut.Start(ctx)).Should(Succeed())
+		Ω(sut.loaded).Should(BeTrue())
+		Ω(sut.configs).Should(HaveLen(2))
+	})
+
+	It("should not be loaded if listing the configurations fails until it is stopped", func() {
+		sut.Reader = &failingReader{Reader: sut.Reader, failures: 1}
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		Ω(sut.Start(ctx)).Should(Succeed())
+		Ω(sut.Readyz(nil)).Should(MatchError("configuration is not loaded yet"))
+	})
+
+	It("should not be ready while an external check loop is not running", func() {
+		Ω(sut.Start(ctx)).Should(Succeed())
+		sut.reconciled(configKindVaultUnsealer, vu)
+		sut.reconciled(configKindSecret, secret)
+		sut.External.apply(types.ExternalKey("default", "ext"), &externalConfig{version: "1"})
+		Ω(sut.Readyz(nil)).Should(MatchError("external handler is not running"))
+
+		sut.External.started = true
+		Ω(sut.Readyz(nil)).Should(MatchError("external check loops are not running: External/default/ext"))
+	})
+
+	It("should detect tasks without progress", func() {
+		sut.LivenessTimeout = time.Minute
+		taskCtx, done := sut.begin(ctx, "Pod default/vault-0")
+		Ω(sut.Livez(nil)).Should(Succeed())
+
+		stall := func() {
+			sut.mux.Lock()
+			defer sut.mux.Unlock()
+			for _, t := range sut.tasks {
+				t.progress = time.Now().Add(-2 * time.Minute)
+			}
+		}
+		stall()
+		Ω(sut.Livez(nil)).Should(MatchError(ContainSubstring("wedged: Pod default/vault-0 (no progress for 2m")))
+
+		// every vault request reports progress
+		reportProgress(taskCtx)
+		Ω(sut.Livez(nil)).Should(Succeed())
+
+		stall()
+		done()
+		Ω(sut.Livez(nil)).Should(Succeed())
+	})
+
+	It("should ignore the tracking without health", func() {
+		var h *Health
+		taskCtx, done := h.begin(ctx, "Pod default/vault-0")
+		reportProgress(taskCtx)
+		done()
+		h.reconciled(configKindVaultUnsealer, vu)
+		h.forget(configKindVaultUnsealer, vu)
+	})
+})
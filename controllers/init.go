@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// initVault initializes the vault of the pod with the init configuration of the VaultInfo.
+// The init output is stored before the vault is unsealed with the new keys. The root token is revoked after the unseal,
+// unless it is stored with the init output. Neither the unseal keys nor the root token are logged.
+func (r *PodReconciler) initVault(
+	ctx context.Context,
+	l logr.Logger,
+	cl *vault.Client,
+	pod *corev1.Pod,
+	vi *types.VaultInfo,
+) error {
+	ic := vi.Init
+	if len(vi.UnsealKeys) > 0 {
+		err := errors.New("the vault is not initialized, but unseal keys are configured")
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonInitFailed, actionInit, err.Error())
+		return err
+	}
+	if err := r.checkInitSecret(ctx, pod.Namespace, ic.SecretName); err != nil {
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonInitFailed, actionInit, err.Error())
+		return err
+	}
+
+	l.Info("vault is not initialized, starting init", "shares", ic.Shares, "threshold", ic.Threshold)
+	resp, err := cl.System.Initialize(ctx, schema.InitializeRequest{
+		SecretShares:    ic.Shares,
+		SecretThreshold: ic.Threshold,
+		PgpKeys:         ic.PGPKeys,
+	})
+	if err != nil {
+		err = fmt.Errorf("could not initialize the vault: %w", err)
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonInitFailed, actionInit, err.Error())
+		return err
+	}
+	out, rootToken, err := initOutputOf(resp.Data, ic)
+	if err != nil {
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonInitFailed, actionInit, err.Error())
+		return err
+	}
+
+	// the output can not be recreated, it is kept until it is stored
+	p := &pendingInit{out: out, rootToken: rootToken}
+	r.inits.set(client.ObjectKeyFromObject(pod).String(), p)
+	return r.completeInit(ctx, l, cl, pod, vi, p)
+}
+
+// completeInit stores the init output and unseals the vault with the new keys. If the output can not be stored,
+// the vault is left sealed and the store is retried with the next reconcile of the pod.
+func (r *PodReconciler) completeInit(
+	ctx context.Context,
+	l logr.Logger,
+	cl *vault.Client,
+	pod *corev1.Pod,
+	vi *types.VaultInfo,
+	p *pendingInit,
+) error {
+	ic := vi.Init
+	if err := r.storeInitOutput(ctx, pod.Namespace, vi, p.out); err != nil {
+		l.Error(err, "could not store the init output")
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonInitOutputNotStored, actionInit, err.Error())
+		return err
+	}
+	r.inits.delete(client.ObjectKeyFromObject(pod).String())
+	recordEvent(r.Recorder, pod, corev1.EventTypeNormal, reasonInitialized, actionInit,
+		fmt.Sprintf("the vault was initialized with %d shares and a threshold of %d", ic.Shares, ic.Threshold))
+
+	keys := p.out.UnsealKeysB64
+	if len(ic.PGPKeys) > 0 {
+		if r.Decrypter == nil {
+			return errors.New("the unseal keys are pgp encrypted, unsealing requires a decryption key")
+		}
+		var err error
+		if keys, err = keysource.Decrypt(r.Decrypter, keys); err != nil {
+			return fmt.Errorf("could not decrypt the unseal keys: %w", err)
+		}
+	}
+	initialized := vi.Clone()
+	initialized.UnsealKeys = keys
+	r.Cache.SetVaultInfoFor(getCacheKeyFor(pod), initialized)
+
+	st, err := cl.System.SealStatus(ctx)
+	if err != nil {
+		return err
+	}
+	rejected, err := unseal(ctx, cl, client.ObjectKeyFromObject(pod).String(), &st.Data, keys)
+	recordUnsealResult(r.Recorder, pod, "", rejected, err)
+	if err != nil {
+		return err
+	}
+	l.Info("successfully initialized and unsealed vault")
+
+	// a root token that can not be revoked is discarded anyway
+	if !ic.StoreRootToken && p.rootToken != "" {
+		if _, err := cl.Auth.TokenRevokeSelf(ctx, vault.WithToken(p.rootToken)); err != nil {
+			l.Error(err, "could not revoke the root token")
+		}
+	}
+	return nil
+}
+
+// pendingInit is the output of an initialization, that was not stored yet.
+type pendingInit struct {
+	out       *keysource.InitOutput
+	rootToken string
+}
+
+// pendingInits tracks the init output of pods until it is stored.
+type pendingInits struct {
+	mux     sync.Mutex
+	outputs map[string]*pendingInit
+}
+
+// get returns the pending init output of the pod, or nil if there is none.
+func (p *pendingInits) get(pod string) *pendingInit {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.outputs[pod]
+}
+
+// set records the pending init output of the pod.
+func (p *pendingInits) set(pod string, init *pendingInit) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.outputs == nil {
+		p.outputs = make(map[string]*pendingInit)
+	}
+	p.outputs[pod] = init
+}
+
+// delete removes the pending init output of the pod, once it is stored.
+func (p *pendingInits) delete(pod string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	delete(p.outputs, pod)
+}
+
+// checkInitSecret ensures the init output does not replace unseal keys stored in the init secret.
+func (r *PodReconciler) checkInitSecret(ctx context.Context, namespace, name string) error {
+	if name == "" {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if kerrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("could not read secret %q: %w", name, err)
+	}
+	src := &keysource.Secret{Namespace: namespace, Name: name, Data: secret.Data}
+	if keys, err := src.Keys(ctx); err != nil || len(keys) > 0 {
+		return fmt.Errorf("refusing to initialize the vault: %s already contains unseal keys", src)
+	}
+	return nil
+}
+
+// initOutputOf converts the response of the init request to the output of 'vault operator init -format=json'.
+// It returns the root token separately, it is only part of the output if it should be stored.
+func initOutputOf(data map[string]any, ic *types.InitConfig) (*keysource.InitOutput, string, error) {
+	out := &keysource.InitOutput{
+		UnsealKeysB64:   stringsOf(data["keys_base64"]),
+		UnsealKeysHex:   stringsOf(data["keys"]),
+		UnsealShares:    ic.Shares,
+		UnsealThreshold: ic.Threshold,
+	}
+	if len(out.UnsealKeysB64) == 0 {
+		return nil, "", errors.New("the init response contains no unseal keys")
+	}
+	rootToken, _ := data["root_token"].(string)
+	if ic.StoreRootToken {
+		out.RootToken = rootToken
+	}
+	return out, rootToken, nil
+}
+
+// stringsOf returns the string values of a JSON array.
+func stringsOf(v any) []string {
+	values, _ := v.([]any)
+	s := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+// storeInitOutput stores the init output in the init secret and the kv secret of the init vault.
+// A failed store is retried with the backoff of the pod, until the output is stored.
+func (r *PodReconciler) storeInitOutput(
+	ctx context.Context,
+	namespace string,
+	vi *types.VaultInfo,
+	out *keysource.InitOutput,
+) error {
+	doc, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if name := vi.Init.SecretName; name != "" {
+		if err := r.storeInitSecret(ctx, namespace, name, doc); err != nil {
+			errs = append(errs, fmt.Errorf("could not store the init output in secret %q: %w", name, err))
+		}
+	}
+	if vi.Init.VaultAddress != "" {
+		data := map[string]any{}
+		if err := json.Unmarshal(doc, &data); err != nil {
+			return err
+		}
+		if err := r.storeInitKV(ctx, vi, data); err != nil {
+			errs = append(errs, fmt.Errorf("could not store the init output in vault %s: %w", vi.Init.VaultAddress, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// storeInitSecret stores the init output in the init.json entry of the secret. The secret is created if it does not exist.
+func (r *PodReconciler) storeInitSecret(ctx context.Context, namespace, name string, doc []byte) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret)
+	if kerrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data:       map[string][]byte{constants.KeyInitJSON: doc},
+		}
+		return r.Client.Create(ctx, secret)
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[constants.KeyInitJSON] = doc
+	return r.Client.Update(ctx, secret)
+}
+
+// storeInitKV stores the init output in the kv secret of the init vault, authenticated with the auth settings
+// of the VaultInfo.
+func (r *PodReconciler) storeInitKV(ctx context.Context, vi *types.VaultInfo, data map[string]any) error {
+	cl, err := newClient(vi.Init.VaultAddress, false, vi)
+	if err != nil {
+		return err
+	}
+	_, err = login(ctx, cl, vi, r.Tokens)
+	metrics.VaultTokenOperations.WithLabelValues(metrics.TokenLogin, metrics.Result(err)).Inc()
+	if err != nil {
+		return fmt.Errorf("could not login: %w", err)
+	}
+	defer revokeToken(ctx, cl)
+
+	mount, path, _ := strings.Cut(vi.Init.VaultPath, "/")
+	return (&keysource.VaultKV{Client: cl, Mount: mount, Path: path}).Store(ctx, data)
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeUninitializedVault is a vault that is initialized with the fixed keys and root token of the fields.
+type fakeUninitializedVault struct {
+	mux         sync.Mutex
+	keys        []string
+	rootToken   string
+	initialized bool
+	sealed      bool
+	init        *schema.InitializeRequest
+	revoked     []string
+}
+
+func (f *fakeUninitializedVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	status := func() schema.SealStatusResponse {
+		return schema.SealStatusResponse{Initialized: f.initialized, Sealed: f.sealed, T: 2, N: int32(len(f.keys))}
+	}
+	switch r.URL.Path {
+	case "/v1/sys/init":
+		f.init = &schema.InitializeRequest{}
+		_ = json.NewDecoder(r.Body).Decode(f.init)
+		f.initialized = true
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": f.keys, "keys_base64": f.keys, "root_token": f.rootToken})
+	case "/v1/sys/seal-status":
+		_ = json.NewEncoder(w).Encode(status())
+	case "/v1/sys/unseal":
+		f.sealed = false
+		_ = json.NewEncoder(w).Encode(status())
+	case "/v1/auth/token/revoke-self":
+		f.revoked = append(f.revoked, r.Header.Get("X-Vault-Token"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("initVault", func() {
+	var (
+		ctx  context.Context
+		sut  *PodReconciler
+		fv   *fakeUninitializedVault
+		srv  *httptest.Server
+		cl   *vault.Client
+		pod  *corev1.Pod
+		vi   *types.VaultInfo
+		key  client.ObjectKey
+		keys []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		keys = []string{"a2V5MQ==", "a2V5Mg==", "a2V5Mw=="}
+		fv = &fakeUninitializedVault{keys: keys, rootToken: "hvs.root", sealed: true}
+		srv = httptest.NewServer(fv)
+		var err error
+		cl, err = newClient(srv.URL, false, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            "vault-0",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "vault"}},
+		}}
+		vi = &types.VaultInfo{
+			StatefulSet: "vault",
+			Init:        &types.InitConfig{Shares: 3, Threshold: 2, SecretName: "unseal"},
+		}
+		key = client.ObjectKey{Namespace: "default", Name: "unseal"}
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sut = &PodReconciler{
+			Client: fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+			Scheme: s,
+			Cache:  cache.NewSimple(false),
+		}
+	}
+
+	storedOutput := func() *keysource.InitOutput {
+		secret := &corev1.Secret{}
+		Ω(sut.Get(ctx, key, secret)).ShouldNot(HaveOccurred())
+		out := &keysource.InitOutput{}
+		Ω(json.Unmarshal(secret.Data[constants.KeyInitJSON], out)).ShouldNot(HaveOccurred())
+		return out
+	}
+
+	It("should initialize the first pod, store the init output and unseal the vault", func() {
+		setup()
+		Ω(sut.initVault(ctx, logr.Discard(), cl, pod, vi)).Should(Succeed())
+
+		Ω(fv.init).Should(Equal(&schema.InitializeRequest{SecretShares: 3, SecretThreshold: 2}))
+		Ω(fv.sealed).Should(BeFalse())
+		Ω(fv.revoked).Should(Equal([]string{"hvs.root"}))
+
+		out := storedOutput()
+		Ω(out.UnsealKeysB64).Should(Equal(keys))
+		Ω(out.UnsealThreshold).Should(Equal(int32(2)))
+		Ω(out.RootToken).Should(BeEmpty())
+
+		cached := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(cached).ShouldNot(BeNil())
+		Ω(cached.UnsealKeys).Should(Equal(keys))
+	})
+
+	It("should store the root token if configured", func() {
+		vi.Init.StoreRootToken = true
+		setup()
+		Ω(sut.initVault(ctx, logr.Discard(), cl, pod, vi)).Should(Succeed())
+
+		Ω(storedOutput().RootToken).Should(Equal("hvs.root"))
+		Ω(fv.revoked).Should(BeEmpty())
+	})
+
+	It("should add the init output to an existing secret without unseal keys", func() {
+		setup(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string][]byte{constants.KeyUsername: []byte("user")},
+		})
+		Ω(sut.initVault(ctx, logr.Discard(), cl, pod, vi)).Should(Succeed())
+
+		secret := &corev1.Secret{}
+		Ω(sut.Get(ctx, key, secret)).ShouldNot(HaveOccurred())
+		Ω(secret.Data).Should(HaveKeyWithValue(constants.KeyUsername, []byte("user")))
+		Ω(secret.Data).Should(HaveKey(constants.KeyInitJSON))
+	})
+
+	It("should refuse to initialize the vault if the secret already contains unseal keys", func() {
+		setup(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string][]byte{constants.KeyPrefixUnsealKey + "1": []byte("foo")},
+		})
+		err := sut.initVault(ctx, logr.Discard(), cl, pod, vi)
+		Ω(err).Should(MatchError(ContainSubstring("already contains unseal keys")))
+		Ω(fv.init).Should(BeNil())
+		Ω(fv.initialized).Should(BeFalse())
+	})
+
+	It("should decrypt pgp encrypted unseal keys to unseal the vault", func() {
+		vi.Init.PGPKeys = []string{"k1", "k2", "k3"}
+		fv.keys = []string{"enc:a2V5MQ==", "enc:a2V5Mg==", "enc:a2V5Mw=="}
+		setup()
+		sut.Decrypter = prefixDecrypter("enc:")
+		Ω(sut.initVault(ctx, logr.Discard(), cl, pod, vi)).Should(Succeed())
+
+		Ω(fv.init.PgpKeys).Should(Equal(vi.Init.PGPKeys))
+		Ω(storedOutput().UnsealKeysB64).Should(Equal(fv.keys))
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault")).UnsealKeys).Should(Equal(keys))
+		Ω(vi.UnsealKeys).Should(BeEmpty())
+		Ω(fv.sealed).Should(BeFalse())
+	})
+
+	It("should keep the vault sealed until the init output is stored", func() {
+		setup()
+		failures := 1
+		s := sut.Scheme
+		sut.Client = fake.NewClientBuilder().WithScheme(s).WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if failures > 0 {
+					failures--
+					return errors.New("etcdserver: request timed out")
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+		}).Build()
+
+		err := sut.initVault(ctx, logr.Discard(), cl, pod, vi)
+		Ω(err).Should(MatchError(ContainSubstring("could not store the init output in secret \"unseal\"")))
+		Ω(fv.initialized).Should(BeTrue())
+		Ω(fv.sealed).Should(BeTrue())
+		Ω(fv.revoked).Should(BeEmpty())
+		Ω(sut.inits.get("default/vault-0")).ShouldNot(BeNil())
+
+		// the next reconcile stores the kept output and unseals the vault
+		Ω(sut.completeInit(ctx, logr.Discard(), cl, pod, vi, sut.inits.get("default/vault-0"))).Should(Succeed())
+		Ω(storedOutput().UnsealKeysB64).Should(Equal(keys))
+		Ω(fv.sealed).Should(BeFalse())
+		Ω(fv.revoked).Should(Equal([]string{"hvs.root"}))
+		Ω(sut.inits.get("default/vault-0")).Should(BeNil())
+	})
+
+	It("should only initialize the first pod of a stateful set", func() {
+		Ω(isFirstPod(pod)).Should(BeTrue())
+		pod.Name = "vault-1"
+		Ω(isFirstPod(pod)).Should(BeFalse())
+		pod.OwnerReferences = nil
+		Ω(isFirstPod(pod)).Should(BeFalse())
+	})
+})
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+)
+
+var (
+	// keyFileDebounce is the delay to wait for further changes, before the keys are reloaded.
+	keyFileDebounce = time.Second
+	// keyFileResyncInterval is the interval in which all key files are reloaded, in case a change was missed.
+	keyFileResyncInterval = 5 * time.Minute
+)
+
+// KeyFileWatcher watches the key files of VaultUnsealers with a file key source.
+// When the files change, the VaultUnsealer is reconciled to reload the unseal keys.
+type KeyFileWatcher struct {
+	mux     sync.Mutex
+	paths   map[client.ObjectKey]string
+	changed chan struct{}
+	events  chan event.GenericEvent
+}
+
+// SetupWithManager adds the watcher to the Manager.
+func (w *KeyFileWatcher) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(w)
+}
+
+// watch starts watching the key files of the given VaultUnsealer.
+func (w *KeyFileWatcher) watch(owner client.ObjectKey, path string) {
+	if w == nil {
+		return
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.paths == nil {
+		w.paths = make(map[client.ObjectKey]string)
+	}
+	if w.paths[owner] != path {
+		w.paths[owner] = path
+		w.notify()
+	}
+}
+
+// unwatch stops watching the key files of the given VaultUnsealer.
+func (w *KeyFileWatcher) unwatch(owner client.ObjectKey) {
+	if w == nil {
+		return
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if _, ok := w.paths[owner]; ok {
+		delete(w.paths, owner)
+		w.notify()
+	}
+}
+
+// notify signals the changed configuration. It must be called with the lock held.
+func (w *KeyFileWatcher) notify() {
+	if w.changed == nil {
+		w.changed = make(chan struct{}, 1)
+	}
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *KeyFileWatcher) changedChan() chan struct{} {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.changed == nil {
+		w.changed = make(chan struct{}, 1)
+	}
+	return w.changed
+}
+
+// eventsChan returns the channel the reconcile events of the VaultUnsealers with changed key files are sent to.
+func (w *KeyFileWatcher) eventsChan() chan event.GenericEvent {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.events == nil {
+		w.events = make(chan event.GenericEvent)
+	}
+	return w.events
+}
+
+// Start watches the key files until the context is done.
+func (w *KeyFileWatcher) Start(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fw.Close() }()
+
+	l := log.FromContext(ctx).WithName("key-files")
+	changed := w.changedChan()
+	resync := time.NewTicker(keyFileResyncInterval)
+	defer resync.Stop()
+
+	watched := make(map[string]bool)
+	pending := make(map[string]bool)
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-changed:
+			w.syncDirs(ctx, fw, watched)
+		case <-resync.C:
+			w.syncDirs(ctx, fw, watched)
+			w.enqueue(ctx, nil)
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return errors.New("key file watcher closed")
+			}
+			for dir := range watched {
+				if isIn(dir, ev.Name) {
+					pending[dir] = true
+				}
+			}
+			debounce = time.After(keyFileDebounce)
+		case <-debounce:
+			w.enqueue(ctx, pending)
+			pending = make(map[string]bool)
+			debounce = nil
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return errors.New("key file watcher closed")
+			}
+			l.Error(err, "error watching key files")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// syncDirs adds and removes the watched directories to match the configured key paths.
+// Directories that can't be watched yet (e.g. a volume that is not mounted) are retried on the next resync.
+func (w *KeyFileWatcher) syncDirs(ctx context.Context, fw *fsnotify.Watcher, watched map[string]bool) {
+	dirs := make(map[string]bool)
+	for _, path := range w.snapshot() {
+		dirs[(&keysource.File{Path: path}).WatchDir()] = true
+	}
+
+	for dir := range watched {
+		if !dirs[dir] {
+			_ = fw.Remove(dir)
+			delete(watched, dir)
+		}
+	}
+	for dir := range dirs {
+		if watched[dir] {
+			continue
+		}
+		if err := fw.Add(dir); err != nil {
+			log.FromContext(ctx).WithValues("dir", dir).Error(err, "could not watch key files")
+			continue
+		}
+		watched[dir] = true
+	}
+}
+
+// enqueue triggers the reconciliation of the VaultUnsealers with key files in the given directories,
+// or of all VaultUnsealers with key files if dirs is nil.
+func (w *KeyFileWatcher) enqueue(ctx context.Context, dirs map[string]bool) {
+	events := w.eventsChan()
+	for owner, path := range w.snapshot() {
+		if dirs != nil && !dirs[(&keysource.File{Path: path}).WatchDir()] {
+			continue
+		}
+		vu := &v1alpha1.VaultUnsealer{ObjectMeta: metav1.ObjectMeta{Namespace: owner.Namespace, Name: owner.Name}}
+		select {
+		case events <- event.GenericEvent{Object: vu}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the watched key paths.
+func (w *KeyFileWatcher) snapshot() map[client.ObjectKey]string {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return maps.Clone(w.paths)
+}
+
+// isIn returns true if the file is the directory itself or one of its direct entries.
+func isIn(dir, file string) bool {
+	return file == dir || filepath.Dir(file) == filepath.Clean(dir)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyFileWatcher", func() {
+	var (
+		sut   *KeyFileWatcher
+		dir   string
+		owner client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		keyFileDebounce = 10 * time.Millisecond
+		sut = &KeyFileWatcher{}
+		dir = GinkgoT().TempDir()
+		owner = client.ObjectKey{Namespace: "default", Name: "vault"}
+	})
+
+	It("should enqueue the vault unsealer when the key files change", func() {
+		sut.watch(owner, dir)
+		events := sut.eventsChan()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = sut.Start(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		// write until the directory is watched
+		var ev event.GenericEvent
+		Eventually(func() bool {
+			Ω(os.WriteFile(filepath.Join(dir, "unsealKey1"), []byte("foo"), 0o600)).ShouldNot(HaveOccurred())
+			select {
+			case ev = <-events:
+				return true
+			default:
+				return false
+			}
+		}).WithTimeout(5 * time.Second).WithPolling(20 * time.Millisecond).Should(BeTrue())
+		Ω(client.ObjectKeyFromObject(ev.Object)).Should(Equal(owner))
+	})
+
+	It("should only enqueue the vault unsealers with key files in the changed directories", func() {
+		other := client.ObjectKey{Namespace: "default", Name: "other"}
+		sut.watch(owner, dir)
+		sut.watch(other, filepath.Join(GinkgoT().TempDir(), "keys.json"))
+		events := sut.eventsChan()
+
+		go sut.enqueue(context.TODO(), map[string]bool{dir: true})
+		var ev event.GenericEvent
+		Eventually(events).Should(Receive(&ev))
+		Ω(client.ObjectKeyFromObject(ev.Object)).Should(Equal(owner))
+		Consistently(events, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should stop watching removed vault unsealers", func() {
+		sut.watch(owner, dir)
+		sut.unwatch(owner)
+		Ω(sut.snapshot()).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+)
+
+// MigrateSecrets creates a VaultUnsealer for each labeled unseal Secret that is not yet referenced by one.
+// The created VaultUnsealers reference the Secret as key source, so the Secret data is used unchanged.
+func MigrateSecrets(
+	ctx context.Context,
+	cl client.Client,
+	secrets []corev1.Secret,
+	unsealers []v1alpha1.VaultUnsealer,
+) ([]v1alpha1.VaultUnsealer, error) {
+	l := log.FromContext(ctx)
+	var created []v1alpha1.VaultUnsealer
+	for _, s := range UnmanagedSecrets(secrets, unsealers) {
+		vu, err := vaultUnsealerForSecret(s)
+		if err != nil {
+			l.WithValues("secret", s.Name).Error(err, "could not migrate secret")
+			continue
+		}
+		if err := cl.Create(ctx, vu); err != nil {
+			if kerrors.IsAlreadyExists(err) {
+				l.WithValues("secret", s.Name).Info("a vault unsealer with the name of the secret already exists")
+				continue
+			}
+			return created, err
+		}
+		l.WithValues("secret", s.Name, "vault-unsealer", vu.Name).Info("migrated secret to vault unsealer")
+		created = append(created, *vu)
+	}
+	return created, nil
+}
+
+// UnmanagedSecrets returns the Secrets that are not referenced by any of the given VaultUnsealers.
+func UnmanagedSecrets(secrets []corev1.Secret, unsealers []v1alpha1.VaultUnsealer) []corev1.Secret {
+	var unmanaged []corev1.Secret
+	for _, s := range secrets {
+		if !slices.ContainsFunc(unsealers, func(vu v1alpha1.VaultUnsealer) bool {
+			return vu.Namespace == s.Namespace && slices.Contains(vu.SecretNames(), s.Name)
+		}) {
+			unmanaged = append(unmanaged, s)
+		}
+	}
+	return unmanaged
+}
+
+// vaultUnsealerForSecret converts a labeled unseal Secret into a VaultUnsealer.
+// The Secret remains the key source, so its auth settings and client certificate are kept. External vaults of labeled
+// Secrets are verified with the system roots, which is the default of a VaultUnsealer without tls settings.
+func vaultUnsealerForSecret(secret corev1.Secret) (*v1alpha1.VaultUnsealer, error) {
+	vu := &v1alpha1.VaultUnsealer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secret.Name,
+			Namespace: secret.Namespace,
+		},
+		Spec: v1alpha1.VaultUnsealerSpec{
+			KeySource: v1alpha1.KeySource{
+				SecretRef: &corev1.LocalObjectReference{Name: secret.Name},
+			},
+		},
+	}
+
+	if sts, ok := secret.Labels[constants.LabelStatefulSetName]; ok {
+		vu.Spec.StatefulSet = sts
+		return vu, nil
+	}
+
+	interval, ok := secret.Labels[constants.LabelExternal]
+	if !ok {
+		return nil, errors.New("secret has neither a stateful-set nor an external label")
+	}
+
+	src := secret.Annotations[constants.AnnotationExternalSource]
+	if src == "" {
+		return nil, errors.New("no source found")
+	}
+	trgt := secret.Annotations[constants.AnnotationExternalTargets]
+	if trgt == "" {
+		return nil, errors.New("no targets found")
+	}
+
+	vu.Spec.External = &v1alpha1.External{
+		Source:  src,
+		Targets: strings.Split(trgt, ";"),
+	}
+	if d, err := time.ParseDuration(interval); err == nil {
+		vu.Spec.Interval = &metav1.Duration{Duration: d}
+	}
+	return vu, nil
+}
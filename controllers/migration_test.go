@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migration", func() {
+	var secret corev1.Secret
+
+	BeforeEach(func() {
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-secret",
+				Namespace: "default",
+			},
+		}
+	})
+
+	Context("vaultUnsealerForSecret", func() {
+		It("should convert a stateful set secret", func() {
+			secret.Labels = map[string]string{constants.LabelStatefulSetName: "vault"}
+			vu, err := vaultUnsealerForSecret(secret)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(vu.Name).Should(Equal(secret.Name))
+			Ω(vu.Namespace).Should(Equal(secret.Namespace))
+			Ω(vu.Spec.StatefulSet).Should(Equal("vault"))
+			Ω(vu.Spec.External).Should(BeNil())
+			Ω(vu.Spec.KeySource.SecretRef.Name).Should(Equal(secret.Name))
+		})
+
+		It("should convert an external secret", func() {
+			secret.Labels = map[string]string{constants.LabelExternal: "3m0s"}
+			secret.Annotations = map[string]string{
+				constants.AnnotationExternalSource:  "https://vault.bakito.org:8200",
+				constants.AnnotationExternalTargets: "https://vault-1.bakito.org:8200;https://vault-2.bakito.org:8200",
+			}
+			vu, err := vaultUnsealerForSecret(secret)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(vu.Spec.StatefulSet).Should(BeEmpty())
+			Ω(vu.Spec.External.Source).Should(Equal("https://vault.bakito.org:8200"))
+			Ω(vu.Spec.External.Targets).Should(Equal([]string{
+				"https://vault-1.bakito.org:8200",
+				"https://vault-2.bakito.org:8200",
+			}))
+			Ω(vu.Spec.Interval.Duration).Should(Equal(3 * time.Minute))
+		})
+
+		It("should keep the connection settings of an external secret", func() {
+			cert, key := newClientCertificate()
+			secret.Labels = map[string]string{constants.LabelExternal: "3m0s"}
+			secret.Annotations = map[string]string{
+				constants.AnnotationExternalSource:  "https://vault.bakito.org:8200",
+				constants.AnnotationExternalTargets: "https://vault-1.bakito.org:8200",
+			}
+			secret.Data = map[string][]byte{
+				constants.KeyPrefixUnsealKey + "1": []byte("foo"),
+				constants.KeyClientCert:            cert,
+				constants.KeyClientKey:             key,
+			}
+			legacy := &ExternalHandler{}
+			vi, err := extractVaultInfo(secret)
+			Ω(err).ShouldNot(HaveOccurred())
+			src, err := legacy.getSourceClient(secret, vi)
+			Ω(err).ShouldNot(HaveOccurred())
+			targets, err := legacy.getTargetClients(secret)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			vu, err := vaultUnsealerForSecret(secret)
+			Ω(err).ShouldNot(HaveOccurred())
+			s := runtime.NewScheme()
+			Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+			r := &VaultUnsealerReconciler{Client: fake.NewClientBuilder().WithScheme(s).WithObjects(&secret).Build()}
+			migrated, err := r.vaultInfoFor(context.TODO(), vu)
+			Ω(err).ShouldNot(HaveOccurred())
+			cfg, err := r.externalConfigFor(context.TODO(), vu, migrated)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(cfg.source.Configuration().TLS).Should(Equal(src.Configuration().TLS))
+			Ω(cfg.targets).Should(HaveLen(1))
+			Ω(cfg.targets[0].Configuration().TLS).Should(Equal(targets[0].Configuration().TLS))
+		})
+
+		It("should fail for an external secret without targets", func() {
+			secret.Labels = map[string]string{constants.LabelExternal: "3m0s"}
+			secret.Annotations = map[string]string{
+				constants.AnnotationExternalSource: "https://vault.bakito.org:8200",
+			}
+			_, err := vaultUnsealerForSecret(secret)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("UnmanagedSecrets", func() {
+		It("should return the secrets not referenced by a vault unsealer", func() {
+			other := *secret.DeepCopy()
+			other.Name = "other"
+			vu := v1alpha1.VaultUnsealer{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "default"},
+				Spec: v1alpha1.VaultUnsealerSpec{
+					KeySource: v1alpha1.KeySource{SecretRef: &corev1.LocalObjectReference{Name: secret.Name}},
+				},
+			}
+
+			unmanaged := UnmanagedSecrets([]corev1.Secret{secret, other}, []v1alpha1.VaultUnsealer{vu})
+			Ω(unmanaged).Should(HaveLen(1))
+			Ω(unmanaged[0].Name).Should(Equal("other"))
+		})
+	})
+})
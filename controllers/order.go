@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/vault-client-go"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// orderPollInterval is the interval in which sealed pods check whether it is their turn to be unsealed.
+const orderPollInterval = 2 * time.Second
+
+// unsealOrder tracks the last unseal and the last active pod of StatefulSets, to unseal their pods in order.
+type unsealOrder struct {
+	mux        sync.Mutex
+	lastUnseal map[types.VaultKey]time.Time
+	lastActive map[types.VaultKey]string
+}
+
+// unsealed records the unseal of a pod of the StatefulSet.
+func (o *unsealOrder) unsealed(key types.VaultKey, now time.Time) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.lastUnseal == nil {
+		o.lastUnseal = make(map[types.VaultKey]time.Time)
+	}
+	o.lastUnseal[key] = now
+}
+
+// active records the active pod of the StatefulSet.
+func (o *unsealOrder) active(key types.VaultKey, pod string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.lastActive == nil {
+		o.lastActive = make(map[types.VaultKey]string)
+	}
+	o.lastActive[key] = pod
+}
+
+// state returns the time of the last unseal and the last active pod of the StatefulSet.
+func (o *unsealOrder) state(key types.VaultKey) (time.Time, string) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.lastUnseal[key], o.lastActive[key]
+}
+
+// unsealTurn returns how long the sealed pod has to wait for its turn, if the pods of its StatefulSet are unsealed
+// in order. The first pod is unsealed right away. Every other pod waits until the pods before it are unsealed,
+// one of them is active or the active timeout has passed, and the settle delay since the last unseal has passed.
+// Pods that can not be reached or are not initialized don't block the pods after them.
+func (r *PodReconciler) unsealTurn(
+	ctx context.Context,
+	l logr.Logger,
+	pod *corev1.Pod,
+	vi *types.VaultInfo,
+) (time.Duration, error) {
+	if !vi.Ordered() {
+		return 0, nil
+	}
+
+	pods, err := r.statefulSetPods(ctx, pod)
+	if err != nil {
+		return 0, err
+	}
+	lastUnseal, lastActive := r.order.state(getCacheKeyFor(pod))
+	if lastActive == "" && vi.UnsealOrder == constants.UnsealOrderLeaderFirst {
+		// after a restart or on another instance, the last active pod is read from the status of the VaultUnsealer
+		if lastActive, err = r.recordedActive(ctx, pod); err != nil {
+			l.V(1).Info("could not read the last active pod", "error", err.Error())
+		}
+	}
+
+	var predecessors int
+	var active bool
+	for _, p := range orderPods(pods, vi.UnsealOrder, lastActive) {
+		if p.Name == pod.Name {
+			break
+		}
+		predecessors++
+		h, err := r.podHealth(ctx, p, vi)
+		if err != nil {
+			l.V(1).Info("could not read the health of pod", "pod", p.Name, "error", err.Error())
+			continue
+		}
+		if h.Initialized && h.Sealed {
+			l.Info("waiting for the unseal of pod", "pod", p.Name)
+			return orderPollInterval, nil
+		}
+		active = active || h.active()
+	}
+
+	wait := time.Until(lastUnseal.Add(vi.SettleDelay))
+	if predecessors > 0 && !active {
+		if remaining := time.Until(lastUnseal.Add(vi.ActiveTimeout)); remaining > 0 {
+			l.Info("waiting for an active pod")
+			wait = max(wait, min(remaining, orderPollInterval))
+		}
+	}
+	return max(wait, 0), nil
+}
+
+// trackActive records the pod as last active pod of its StatefulSet, if it is active.
+// The pod is also recorded in the status of the VaultUnsealer of the StatefulSet, to be known after a restart of the
+// unsealer and on the other instances.
+func (r *PodReconciler) trackActive(ctx context.Context, cl *vault.Client, pod *corev1.Pod) {
+	h, err := healthOf(ctx, cl)
+	if err != nil || !h.active() {
+		return
+	}
+	key := getCacheKeyFor(pod)
+	if _, lastActive := r.order.state(key); lastActive == pod.Name {
+		return
+	}
+	r.order.active(key, pod.Name)
+	if err := r.recordActive(ctx, pod); err != nil {
+		log.FromContext(ctx).Error(err, "could not record the active pod in the vault unsealer status")
+	}
+}
+
+// recordActive records the pod as last active pod in the status of the VaultUnsealer of its StatefulSet.
+func (r *PodReconciler) recordActive(ctx context.Context, pod *corev1.Pod) error {
+	vu, err := r.unsealerOf(ctx, pod)
+	if err != nil || vu == nil || vu.Status.LastActivePod == pod.Name {
+		return err
+	}
+	patch := client.MergeFrom(vu.DeepCopy())
+	vu.Status.LastActivePod = pod.Name
+	return r.Status().Patch(ctx, vu, patch)
+}
+
+// recordedActive returns the last active pod recorded in the status of the VaultUnsealer of the StatefulSet of the pod.
+func (r *PodReconciler) recordedActive(ctx context.Context, pod *corev1.Pod) (string, error) {
+	vu, err := r.unsealerOf(ctx, pod)
+	if err != nil || vu == nil {
+		return "", err
+	}
+	return vu.Status.LastActivePod, nil
+}
+
+// unsealerOf returns the VaultUnsealer of the StatefulSet of the pod, or nil if there is none.
+func (r *PodReconciler) unsealerOf(ctx context.Context, pod *corev1.Pod) (*v1alpha1.VaultUnsealer, error) {
+	list := &v1alpha1.VaultUnsealerList{}
+	if err := r.List(ctx, list, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	sts := getStatefulSetFor(pod)
+	for i := range list.Items {
+		if vu := &list.Items[i]; vu.Spec.External == nil && vu.Spec.StatefulSet == sts {
+			return vu, nil
+		}
+	}
+	return nil, nil
+}
+
+// statefulSetPods returns the running pods of the StatefulSet of the pod.
+func (r *PodReconciler) statefulSetPods(ctx context.Context, pod *corev1.Pod) ([]*corev1.Pod, error) {
+	return r.podsOf(ctx, pod.Namespace, getStatefulSetFor(pod))
+}
+
+// podsOf returns the running pods of the given StatefulSet.
+func (r *PodReconciler) podsOf(ctx context.Context, namespace, statefulSet string) ([]*corev1.Pod, error) {
+	list := &corev1.PodList{}
+	if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var pods []*corev1.Pod
+	for i := range list.Items {
+		p := &list.Items[i]
+		if getStatefulSetFor(p) == statefulSet && r.matches(p) {
+			pods = append(pods, p)
+		}
+	}
+	return pods, nil
+}
+
+// orderPods orders the pods by ordinal. With the LeaderFirst strategy, the last active pod is ordered first.
+func orderPods(pods []*corev1.Pod, strategy, lastActive string) []*corev1.Pod {
+	first := func(p *corev1.Pod) int {
+		if strategy == constants.UnsealOrderLeaderFirst && lastActive != "" && p.Name == lastActive {
+			return 0
+		}
+		return 1
+	}
+	ordered := slices.Clone(pods)
+	slices.SortFunc(ordered, func(a, b *corev1.Pod) int {
+		return cmp.Or(
+			cmp.Compare(first(a), first(b)),
+			cmp.Compare(ordinalOf(a), ordinalOf(b)),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	return ordered
+}
+
+// vaultHealth is the health of a vault reported by sys/health.
+type vaultHealth struct {
+	Initialized bool
+	Sealed      bool
+	Standby     bool
+}
+
+// active returns true if the vault is the active node.
+func (h *vaultHealth) active() bool {
+	return h.Initialized && !h.Sealed && !h.Standby
+}
+
+// podHealth reads the health of the vault of the pod.
+func (r *PodReconciler) podHealth(ctx context.Context, pod *corev1.Pod, vi *types.VaultInfo) (*vaultHealth, error) {
+	cl, err := newClient(getVaultAddress(ctx, pod, r.VaultContainerName, r.AddrEnvVarName), true, vi)
+	if err != nil {
+		return nil, err
+	}
+	return healthOf(ctx, cl)
+}
+
+// healthOf reads the health of the vault. All states are reported with status 200, to read them from the response.
+func healthOf(ctx context.Context, cl *vault.Client) (*vaultHealth, error) {
+	ok := []string{"200"}
+	resp, err := cl.System.ReadHealthStatus(ctx, vault.WithQueryParameters(url.Values{
+		"standbycode":     ok,
+		"perfstandbycode": ok,
+		"drsecondarycode": ok,
+		"sealedcode":      ok,
+		"uninitcode":      ok,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	h := &vaultHealth{}
+	h.Initialized, _ = resp.Data["initialized"].(bool)
+	h.Sealed, _ = resp.Data["sealed"].(bool)
+	h.Standby, _ = resp.Data["standby"].(bool)
+	return h, nil
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("unsealTurn", func() {
+	var (
+		ctx    context.Context
+		sut    *PodReconciler
+		health map[string]any
+		srv    *httptest.Server
+		vi     *types.VaultInfo
+		key    types.VaultKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		health = map[string]any{"initialized": true, "sealed": false, "standby": false}
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(health)
+		}))
+		vi = &types.VaultInfo{
+			StatefulSet:   "vault",
+			UnsealOrder:   constants.UnsealOrderOrdered,
+			SettleDelay:   time.Minute,
+			ActiveTimeout: time.Hour,
+		}
+		key = types.StatefulSetKey("default", "vault")
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		Ω(v1alpha1.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sut = &PodReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(s).
+				WithObjects(objs...).
+				WithStatusSubresource(&v1alpha1.VaultUnsealer{}).
+				Build(),
+			Scheme: s,
+			Cache:  cache.NewSimple(false),
+		}
+		sut.Cache.SetVaultInfoFor(key, vi)
+	}
+
+	It("should not wait with the parallel strategy", func() {
+		vi.UnsealOrder = constants.UnsealOrderParallel
+		setup()
+		Ω(sut.unsealTurn(ctx, logr.Discard(), vaultPod("vault-1", srv.URL), vi)).Should(BeZero())
+	})
+
+	It("should unseal the first pod right away", func() {
+		health["sealed"] = true
+		pod := vaultPod("vault-0", srv.URL)
+		setup(pod, vaultPod("vault-1", srv.URL))
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeZero())
+	})
+
+	It("should wait for the unseal of the pods before", func() {
+		health["sealed"] = true
+		pod := vaultPod("vault-1", srv.URL)
+		setup(vaultPod("vault-0", srv.URL), pod)
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(Equal(orderPollInterval))
+	})
+
+	It("should unseal the next pod once the pod before is active and the settle delay has passed", func() {
+		pod := vaultPod("vault-1", srv.URL)
+		setup(vaultPod("vault-0", srv.URL), pod)
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeZero())
+
+		sut.order.unsealed(key, time.Now())
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeNumerically("~", time.Minute, time.Second))
+	})
+
+	It("should wait for an active pod until the active timeout has passed", func() {
+		health["standby"] = true
+		vi.SettleDelay = 0
+		pod := vaultPod("vault-1", srv.URL)
+		setup(vaultPod("vault-0", srv.URL), pod)
+
+		sut.order.unsealed(key, time.Now())
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(Equal(orderPollInterval))
+
+		sut.order.unsealed(key, time.Now().Add(-vi.ActiveTimeout))
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeZero())
+	})
+
+	It("should unseal the last active pod first", func() {
+		vi.UnsealOrder = constants.UnsealOrderLeaderFirst
+		health["sealed"] = true
+		pod := vaultPod("vault-2", srv.URL)
+		setup(vaultPod("vault-0", srv.URL), vaultPod("vault-1", srv.URL), pod)
+
+		sut.order.active(key, "vault-2")
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeZero())
+		Ω(sut.unsealTurn(ctx, logr.Discard(), vaultPod("vault-0", srv.URL), vi)).Should(Equal(orderPollInterval))
+	})
+
+	It("should record the active pod in the status of the vault unsealer", func() {
+		vi.UnsealOrder = constants.UnsealOrderLeaderFirst
+		vu := &v1alpha1.VaultUnsealer{
+			ObjectMeta: metav1.ObjectMeta{Name: "unsealer", Namespace: "default"},
+			Spec:       v1alpha1.VaultUnsealerSpec{StatefulSet: "vault"},
+		}
+		pod := vaultPod("vault-2", srv.URL)
+		setup(vaultPod("vault-0", srv.URL), vaultPod("vault-1", srv.URL), pod, vu)
+		cl, err := newClient(srv.URL, true, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		sut.trackActive(ctx, cl, pod)
+		Ω(sut.Get(ctx, client.ObjectKeyFromObject(vu), vu)).ShouldNot(HaveOccurred())
+		Ω(vu.Status.LastActivePod).Should(Equal("vault-2"))
+
+		// a restarted unsealer reads the last active pod from the status
+		sut.order = unsealOrder{}
+		health["sealed"] = true
+		Ω(sut.unsealTurn(ctx, logr.Discard(), pod, vi)).Should(BeZero())
+		Ω(sut.unsealTurn(ctx, logr.Discard(), vaultPod("vault-0", srv.URL), vi)).Should(Equal(orderPollInterval))
+	})
+
+	It("should order the pods by ordinal", func() {
+		pods := []*corev1.Pod{vaultPod("vault-10", srv.URL), vaultPod("vault-2", srv.URL), vaultPod("vault-1", srv.URL)}
+		names := func(pods []*corev1.Pod) []string {
+			var n []string
+			for _, p := range pods {
+				n = append(n, p.Name)
+			}
+			return n
+		}
+		Ω(names(orderPods(pods, constants.UnsealOrderOrdered, "vault-2"))).
+			Should(Equal([]string{"vault-1", "vault-2", "vault-10"}))
+		Ω(names(orderPods(pods, constants.UnsealOrderLeaderFirst, "vault-2"))).
+			Should(Equal([]string{"vault-2", "vault-1", "vault-10"}))
+	})
+})
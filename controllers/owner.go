@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// ownedEntry is a cache entry managed by a configuration object.
+type ownedEntry struct {
+	key  types.VaultKey
+	info *types.VaultInfo
+	// version is the version of the external check loop configuration.
+	version string
+}
+
+// sameTarget returns true if both entries manage the same cache entry.
+func (e ownedEntry) sameTarget(other ownedEntry) bool {
+	return e.key == other.key
+}
+
+// cacheOwner tracks the cache entries and external check loops managed by configuration objects.
+type cacheOwner struct {
+	mux     sync.Mutex
+	entries map[client.ObjectKey]ownedEntry
+}
+
+// get returns the entry managed by the given object.
+func (o *cacheOwner) get(key client.ObjectKey) (ownedEntry, bool) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	e, ok := o.entries[key]
+	return e, ok
+}
+
+// set records the entry managed by the given object.
+func (o *cacheOwner) set(key client.ObjectKey, e ownedEntry) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.entries == nil {
+		o.entries = make(map[client.ObjectKey]ownedEntry)
+	}
+	o.entries[key] = e
+}
+
+// release removes the cache entry and the external check loop managed by the given object.
+// The cache entry and check loop are only removed if they were not replaced by another configuration object.
+func (o *cacheOwner) release(key client.ObjectKey, c cache.Cache, external *ExternalHandler) {
+	o.mux.Lock()
+	e, ok := o.entries[key]
+	delete(o.entries, key)
+	o.mux.Unlock()
+	if !ok {
+		return
+	}
+
+	if c.VaultInfoFor(e.key) == e.info {
+		c.DeleteVaultInfoFor(e.key)
+	}
+	if e.key.Kind == types.KindExternal && external != nil {
+		external.remove(e.key, e.version)
+	}
+}
@@ -2,19 +2,26 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/bakito/vault-unsealer/pkg/cache"
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 )
 
 // PodReconciler reconciles a Pod object.
@@ -24,19 +31,41 @@ type PodReconciler struct {
 	Cache              cache.Cache
 	VaultContainerName string
 	AddrEnvVarName     string
+	Tokens             *ServiceAccountTokens
+	VaultTokens        *VaultTokens
+	Recorder           events.EventRecorder
+	// Decrypter decrypts the unseal keys of vaults initialized with pgp keys.
+	Decrypter keysource.Decrypter
+	// Health tracks the progress of the running reconciles, to detect wedged reconciles.
+	Health *Health
+
+	// events triggers the reconciliation of pods independent of pod changes.
+	events chan event.GenericEvent
+	// order tracks the unseals and active pods of StatefulSets unsealed in order.
+	order unsealOrder
+	// retries tracks the failed reconciles of pods.
+	retries retries
+	// inits tracks the init output of initialized pods, that could not be stored yet.
+	inits pendingInits
 }
 
 // +kubebuilder:rbac:groups=,resources=pods;secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=,resources=secrets,verbs=create;update
 // +kubebuilder:rbac:groups=,resources=pods/status,verbs=get
+// +kubebuilder:rbac:groups=,resources=serviceaccounts/token,verbs=create
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile reconciles the Pod object.
 func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
+	ctx, done := r.Health.begin(ctx, "Pod "+req.String())
+	defer done()
 
 	pod := &corev1.Pod{}
 	err := r.Get(ctx, req.NamespacedName, pod)
 	if err != nil {
 		if kerrors.IsNotFound(err) {
+			metrics.Sealed.DeleteLabelValues(req.String())
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the req.
@@ -44,15 +73,34 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return reconcile.Result{}, err
 	}
 
+	// A pod that failed recently is retried once its backoff has passed, independent of the event that triggered it.
+	target := client.ObjectKeyFromObject(pod).String()
+	if wait := r.retries.pending(target); wait > 0 {
+		return reconcile.Result{RequeueAfter: wait}, nil
+	}
+
 	// Perform reconciliation logic for the Vault Pod.
-	return r.reconcileVaultPod(ctx, l, pod)
+	res, err := r.reconcileVaultPod(ctx, l, pod)
+	if err != nil {
+		delay := r.retries.failed(target, err, retryMaxDelay)
+		l.WithValues("failures", r.retries.failures(target), "retry-in", delay.String()).
+			Error(err, "Error reconciling vault pod")
+		return reconcile.Result{RequeueAfter: delay}, nil
+	}
+	if res.RequeueAfter == 0 {
+		r.retries.reset(target)
+	}
+	return res, nil
 }
 
 // reconcileVaultPod reconciles a Vault Pod.
 func (r *PodReconciler) reconcileVaultPod(ctx context.Context, l logr.Logger, pod *corev1.Pod) (ctrl.Result, error) {
 	// Get the address of the Vault server.
 	addr := getVaultAddress(ctx, pod, r.VaultContainerName, r.AddrEnvVarName)
-	cl, err := newClient(addr, true)
+	key := getCacheKeyFor(pod)
+	target := client.ObjectKeyFromObject(pod).String()
+	vi := r.Cache.VaultInfoFor(key)
+	cl, err := newClient(addr, true, vi)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -63,15 +111,38 @@ func (r *PodReconciler) reconcileVaultPod(ctx context.Context, l logr.Logger, po
 		l.Error(err, "Error checking seal status")
 		return reconcile.Result{}, err
 	}
+	metrics.Sealed.WithLabelValues(target).Set(metrics.Bool(st.Data.Sealed))
+
+	// An initialization whose output could not be stored is completed first, the vault stays sealed until then.
+	if p := r.inits.get(target); p != nil && vi != nil && vi.Init != nil {
+		return reconcile.Result{}, r.completeInit(ctx, l, cl, pod, vi, p)
+	}
 
-	// If the Vault server is not initialized, requeue after 10 seconds.
+	// If the Vault server is not initialized, join the raft cluster or initialize the first pod if configured,
+	// otherwise wait for its initialization.
 	if !st.Data.Initialized {
-		l.Info("vault is not initialized")
-		return reconcile.Result{RequeueAfter: time.Second * 10}, nil
+		joined, err := r.joinRaft(ctx, l, cl, pod, vi, &st.Data)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		switch {
+		case joined:
+			// the joined node is unsealed with the unseal keys of the cluster
+			if st, err = cl.System.SealStatus(ctx); err != nil {
+				l.Error(err, "Error checking seal status")
+				return reconcile.Result{}, err
+			}
+		case vi != nil && vi.Init != nil && isFirstPod(pod):
+			return reconcile.Result{}, r.initVault(ctx, l, cl, pod, vi)
+		default:
+			l.Info("vault is not initialized")
+			recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonNotInitialized, actionCheck,
+				"the vault is not initialized")
+			return r.waitFor(pod, errNotInitialized), nil
+		}
 	}
 
-	// Get the VaultInfo for the StatefulSet associated with the Pod.
-	vi := r.Cache.VaultInfoFor(getStatefulSetFor(pod))
+	// Without VaultInfo for the StatefulSet associated with the Pod, there is nothing to do.
 	if vi == nil {
 		return reconcile.Result{}, nil
 	}
@@ -82,49 +153,112 @@ func (r *PodReconciler) reconcileVaultPod(ctx context.Context, l logr.Logger, po
 		"stateful-set", vi.StatefulSet,
 	)
 
+	if !st.Data.Sealed && vi.UnsealOrder == constants.UnsealOrderLeaderFirst {
+		r.trackActive(ctx, cl, pod)
+	}
+
 	// If the Vault server is sealed, unseal it.
 	if st.Data.Sealed {
 		l.Info("vault is sealed, starting unseal")
 		if len(vi.UnsealKeys) == 0 {
-			return reconcile.Result{RequeueAfter: time.Second * 10}, nil
+			recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonSealed, actionCheck,
+				"the vault is sealed, no unseal keys are available")
+			return r.waitFor(pod, errNoUnsealKeys), nil
+		}
+		// If the pods of the StatefulSet are unsealed in order, wait for the turn of the pod.
+		if wait, err := r.unsealTurn(ctx, l, pod, vi); err != nil || wait > 0 {
+			if err == nil {
+				recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonSealed, actionCheck,
+					"the vault is sealed, waiting for its turn to be unsealed")
+			}
+			return reconcile.Result{RequeueAfter: wait}, err
+		}
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonSealed, actionCheck, "the vault is sealed, starting unseal")
+		keys, err := unsealKeysFor(ctx, vi, r.Tokens)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", errDecryptKeys, err)
+			observeUnseal(target, err)
+			recordUnsealResult(r.Recorder, pod, "", nil, err)
+			return reconcile.Result{}, err
 		}
-		if err := unseal(ctx, cl, vi); err != nil {
+		rejected, err := unseal(ctx, cl, target, &st.Data, keys)
+		recordUnsealResult(r.Recorder, pod, "", rejected, err)
+		if err != nil {
 			return reconcile.Result{}, err
 		}
+		r.order.unsealed(key, time.Now())
+		if started := startedAt(pod, r.VaultContainerName); !started.IsZero() {
+			metrics.TimeToUnseal.WithLabelValues(key.String()).Observe(time.Since(started).Seconds())
+		}
 		vaultLog.Info("successfully unsealed vault")
 
 		// If the Vault server is unsealed and there are no unseal keys, authenticate.
 	} else if len(vi.UnsealKeys) == 0 {
 		l.Info("no unseal info found, starting lookup")
-		if err = login(ctx, cl, vi); err != nil {
+		if err = r.VaultTokens.login(ctx, cl, key, vi, r.Tokens); err != nil {
 			l.Error(err, "login error")
+			recordKeysLoaded(r.Recorder, pod, 0, err, nil)
 			return reconcile.Result{}, err
 		}
 
-		if err := readUnsealKeys(ctx, cl, vi); err != nil {
+		err = readUnsealKeys(ctx, cl, vi)
+		r.VaultTokens.release(ctx, cl)
+		recordKeysLoaded(r.Recorder, pod, len(vi.UnsealKeys), nil, err)
+		if err != nil {
 			return reconcile.Result{}, err
 		}
 
-		r.Cache.SetVaultInfoFor(vi.StatefulSet, vi)
+		r.Cache.SetVaultInfoFor(key, vi)
 		vaultLog.WithValues("keys", len(vi.UnsealKeys)).Info("successfully read unseal keys from vault")
 	}
 
 	return ctrl.Result{}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager, secrets []corev1.Secret) error {
-	// Populate the cache with VaultInfo from the provided Secrets.
-	for _, s := range secrets {
-		statefulSet := s.GetLabels()[constants.LabelStatefulSetName]
-		if r.Cache.VaultInfoFor(statefulSet) == nil {
-			v := extractVaultInfo(s)
-			r.Cache.SetVaultInfoFor(statefulSet, v)
+// waitFor requeues a pod that waits for its initialization or for unseal keys with an exponential backoff.
+func (r *PodReconciler) waitFor(pod *corev1.Pod, reason error) ctrl.Result {
+	return reconcile.Result{RequeueAfter: r.retries.failed(client.ObjectKeyFromObject(pod).String(), reason, waitMaxDelay)}
+}
+
+// EnqueueStatefulSet triggers the reconciliation of all running pods of the given StatefulSet.
+// It is used to unseal pods that were started before the configuration of their StatefulSet was known
+// or are waiting for a retry with the previous configuration.
+func (r *PodReconciler) EnqueueStatefulSet(ctx context.Context, namespace, statefulSet string) error {
+	if r == nil || r.events == nil {
+		return nil
+	}
+
+	pods, err := r.podsOf(ctx, namespace, statefulSet)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		// the configuration changed, retry pods in backoff right away
+		r.retries.reset(client.ObjectKeyFromObject(pod).String())
+		if err := r.enqueue(ctx, pod); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// enqueue triggers the reconciliation of the pod.
+func (r *PodReconciler) enqueue(ctx context.Context, pod *corev1.Pod) error {
+	select {
+	case r.events <- event.GenericEvent{Object: pod}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.events = make(chan event.GenericEvent)
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
+		WatchesRawSource(source.Channel(r.events, &handler.EnqueueRequestForObject{})).
 		WithEventFilter(r).
 		Complete(r)
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
 // getStatefulSetFor returns the name of the StatefulSet that owns the given Pod.
@@ -21,6 +25,30 @@ func getStatefulSetFor(pod *corev1.Pod) string {
 	return ""
 }
 
+// isFirstPod returns true if the Pod is the first pod (ordinal 0) of its StatefulSet.
+func isFirstPod(pod *corev1.Pod) bool {
+	sts := getStatefulSetFor(pod)
+	return sts != "" && pod.Name == sts+"-0"
+}
+
+// ordinalOf returns the ordinal of the StatefulSet pod, -1 if the pod name has no ordinal.
+func ordinalOf(pod *corev1.Pod) int {
+	i := strings.LastIndex(pod.Name, "-")
+	if i < 0 {
+		return -1
+	}
+	ordinal, err := strconv.Atoi(pod.Name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+// getCacheKeyFor returns the cache key of the StatefulSet that owns the given Pod.
+func getCacheKeyFor(pod *corev1.Pod) types.VaultKey {
+	return types.StatefulSetKey(pod.Namespace, getStatefulSetFor(pod))
+}
+
 // getVaultAddress returns the address of the Vault service running in the given Pod.
 func getVaultAddress(ctx context.Context, pod *corev1.Pod, containerName, addrEnvName string) string {
 	// Check if development mode is enabled.
@@ -60,6 +88,22 @@ func getVaultAddress(ctx context.Context, pod *corev1.Pod, containerName, addrEn
 	return ""
 }
 
+// startedAt returns the time the vault container of the pod was started, or the start time of the pod
+// if the container state is not known.
+func startedAt(pod *corev1.Pod, containerName string) time.Time {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == containerName || cs.Name == constants.ContainerNameVault || cs.Name == constants.ContainerNameOpenbao {
+			if cs.State.Running != nil {
+				return cs.State.Running.StartedAt.Time
+			}
+		}
+	}
+	if pod.Status.StartTime != nil {
+		return pod.Status.StartTime.Time
+	}
+	return time.Time{}
+}
+
 func determineEnvName(containerName, defaultEnvName string) string {
 	if defaultEnvName != "" {
 		return defaultEnvName
@@ -3,7 +3,10 @@ package controllers
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 )
 
 // Create is invoked when a new Pod is created.
@@ -16,8 +19,11 @@ func (r *PodReconciler) Update(e event.UpdateEvent) bool {
 	return r.matches(e.ObjectNew)
 }
 
-// Delete is invoked when a Pod is deleted.
-func (*PodReconciler) Delete(_ event.DeleteEvent) bool {
+// Delete is invoked when a Pod is deleted. Deleted pods are not reconciled, only their sealed metric is removed.
+func (*PodReconciler) Delete(e event.DeleteEvent) bool {
+	if e.Object != nil {
+		metrics.Sealed.DeleteLabelValues(client.ObjectKeyFromObject(e.Object).String())
+	}
 	return false
 }
 
@@ -39,6 +45,5 @@ func (r *PodReconciler) matches(m metav1.Object) bool {
 
 // hasCorrectOwner checks if the given Pod has the correct owner (StatefulSet).
 func (r *PodReconciler) hasCorrectOwner(pod *corev1.Pod) bool {
-	owner := getStatefulSetFor(pod)
-	return r.Cache.VaultInfoFor(owner) != nil
+	return r.Cache.VaultInfoFor(getCacheKeyFor(pod)) != nil
 }
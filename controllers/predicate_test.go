@@ -7,6 +7,7 @@ import (
 
 	"github.com/bakito/vault-unsealer/controllers"
 	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 	"github.com/bakito/vault-unsealer/pkg/types"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -24,7 +25,7 @@ var _ = Describe("PodReconciler", func() {
 			Cache: cache.NewSimple(false),
 		}
 
-		reconciler.Cache.SetVaultInfoFor("test-statefulset", &types.VaultInfo{})
+		reconciler.Cache.SetVaultInfoFor(types.StatefulSetKey("default", "test-statefulset"), &types.VaultInfo{})
 
 		pod = &corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
@@ -51,6 +52,12 @@ var _ = Describe("PodReconciler", func() {
 			createEvent := event.CreateEvent{Object: pod}
 			Expect(reconciler.Create(createEvent)).To(BeFalse())
 		})
+
+		It("should return false for a Pod of a StatefulSet with the same name in another namespace", func() {
+			pod.Namespace = "other"
+			createEvent := event.CreateEvent{Object: pod}
+			Expect(reconciler.Create(createEvent)).To(BeFalse())
+		})
 	})
 
 	Context("Update", func() {
@@ -71,6 +78,12 @@ var _ = Describe("PodReconciler", func() {
 			deleteEvent := event.DeleteEvent{}
 			Expect(reconciler.Delete(deleteEvent)).To(BeFalse())
 		})
+
+		It("should remove the sealed metric of the Pod", func() {
+			metrics.Sealed.WithLabelValues("default/test-pod").Set(1)
+			Expect(reconciler.Delete(event.DeleteEvent{Object: pod})).To(BeFalse())
+			Expect(metrics.Sealed.DeleteLabelValues("default/test-pod")).To(BeFalse())
+		})
 	})
 
 	Context("Generic", func() {
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// storageTypeRaft is the storage type of vaults with integrated raft storage.
+const storageTypeRaft = "raft"
+
+// joinRaft joins the uninitialized raft node of the pod to the raft cluster of the active node of its StatefulSet.
+// It returns false without joining, if raft join is not configured, the vault has no raft storage
+// or no active node was found.
+func (r *PodReconciler) joinRaft(
+	ctx context.Context,
+	l logr.Logger,
+	cl *vault.Client,
+	pod *corev1.Pod,
+	vi *types.VaultInfo,
+	st *schema.SealStatusResponse,
+) (bool, error) {
+	if vi == nil || vi.RaftJoin == nil || st.StorageType != storageTypeRaft {
+		return false, nil
+	}
+
+	leader, err := r.activeNodeOf(ctx, l, pod, vi)
+	if err != nil || leader == "" {
+		return false, err
+	}
+
+	req := map[string]any{"leader_api_addr": leader}
+	if vi.RaftJoin.LeaderCACert != "" {
+		req["leader_ca_cert"] = vi.RaftJoin.LeaderCACert
+	}
+	if vi.RaftJoin.LeaderTLSServerName != "" {
+		req["leader_tls_servername"] = vi.RaftJoin.LeaderTLSServerName
+	}
+
+	l.Info("vault is not initialized, joining the raft cluster", "leader", leader)
+	resp, err := cl.Write(ctx, "/v1/sys/storage/raft/join", req)
+	if err == nil {
+		if joined, _ := resp.Data["joined"].(bool); !joined {
+			err = fmt.Errorf("the join was not accepted by %s", leader)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("could not join the raft cluster of %s: %w", leader, err)
+		recordEvent(r.Recorder, pod, corev1.EventTypeWarning, reasonRaftJoinFailed, actionRaftJoin, err.Error())
+		return false, err
+	}
+
+	recordEvent(r.Recorder, pod, corev1.EventTypeNormal, reasonRaftJoined, actionRaftJoin,
+		"joined the raft cluster of "+leader)
+	return true, nil
+}
+
+// activeNodeOf returns the api address of the active node of the StatefulSet of the pod, discovered from its other pods.
+// The address reported by the active node itself is preferred over the leader address reported by standby nodes.
+func (r *PodReconciler) activeNodeOf(
+	ctx context.Context,
+	l logr.Logger,
+	pod *corev1.Pod,
+	vi *types.VaultInfo,
+) (string, error) {
+	pods, err := r.statefulSetPods(ctx, pod)
+	if err != nil {
+		return "", err
+	}
+
+	var reported string
+	for _, sibling := range pods {
+		if sibling.Name == pod.Name {
+			continue
+		}
+		addr := getVaultAddress(ctx, sibling, r.VaultContainerName, r.AddrEnvVarName)
+		if addr == "" {
+			continue
+		}
+		cl, err := newClient(addr, true, vi)
+		if err != nil {
+			return "", err
+		}
+		resp, err := cl.System.LeaderStatus(ctx)
+		if err != nil {
+			l.V(1).Info("could not read the leader status", "pod", sibling.Name, "error", err.Error())
+			continue
+		}
+		if resp.Data.IsSelf {
+			if resp.Data.LeaderAddress != "" {
+				return resp.Data.LeaderAddress, nil
+			}
+			return addr, nil
+		}
+		if reported == "" {
+			reported = resp.Data.LeaderAddress
+		}
+	}
+	return reported, nil
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("joinRaft", func() {
+	var (
+		ctx      context.Context
+		sut      *PodReconciler
+		leader   schema.LeaderStatusResponse
+		joinReq  map[string]any
+		joinResp map[string]any
+		sibling  *httptest.Server
+		node     *httptest.Server
+		cl       *vault.Client
+		pod      *corev1.Pod
+		vi       *types.VaultInfo
+		st       *schema.SealStatusResponse
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		leader = schema.LeaderStatusResponse{
+			HaEnabled:     true,
+			IsSelf:        true,
+			LeaderAddress: "https://vault-0.vault-internal:8200",
+		}
+		joinReq = nil
+		joinResp = map[string]any{"joined": true}
+		sibling = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(leader)
+		}))
+		node = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/sys/storage/raft/join" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewDecoder(r.Body).Decode(&joinReq)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(joinResp)
+		}))
+		var err error
+		cl, err = newClient(node.URL, false, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		pod = vaultPod("vault-1", node.URL)
+		vi = &types.VaultInfo{StatefulSet: "vault", RaftJoin: &types.RaftJoinConfig{}}
+		st = &schema.SealStatusResponse{Sealed: true, StorageType: storageTypeRaft}
+	})
+
+	AfterEach(func() {
+		sibling.Close()
+		node.Close()
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sut = &PodReconciler{
+			Client: fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+			Scheme: s,
+			Cache:  cache.NewSimple(false),
+		}
+		sut.Cache.SetVaultInfoFor(types.StatefulSetKey("default", "vault"), vi)
+	}
+
+	It("should join the raft cluster of the active node", func() {
+		vi.RaftJoin = &types.RaftJoinConfig{LeaderCACert: "ca", LeaderTLSServerName: "vault.vault-internal"}
+		setup(pod, vaultPod("vault-0", sibling.URL))
+
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeTrue())
+		Ω(joinReq).Should(Equal(map[string]any{
+			"leader_api_addr":       "https://vault-0.vault-internal:8200",
+			"leader_ca_cert":        "ca",
+			"leader_tls_servername": "vault.vault-internal",
+		}))
+	})
+
+	It("should use the pod address of an active node without leader address", func() {
+		leader.LeaderAddress = ""
+		setup(pod, vaultPod("vault-0", sibling.URL))
+
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeTrue())
+		Ω(joinReq).Should(HaveKeyWithValue("leader_api_addr", sibling.URL))
+	})
+
+	It("should use the leader address reported by a standby node", func() {
+		leader.IsSelf = false
+		setup(pod, vaultPod("vault-2", sibling.URL))
+
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeTrue())
+		Ω(joinReq).Should(HaveKeyWithValue("leader_api_addr", "https://vault-0.vault-internal:8200"))
+	})
+
+	It("should not join without an active node", func() {
+		leader = schema.LeaderStatusResponse{}
+		setup(pod, vaultPod("vault-0", sibling.URL))
+
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeFalse())
+		Ω(joinReq).Should(BeNil())
+	})
+
+	It("should not join vaults without raft storage or raft join config", func() {
+		setup(pod, vaultPod("vault-0", sibling.URL))
+
+		st.StorageType = "consul"
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeFalse())
+		st.StorageType = storageTypeRaft
+		vi.RaftJoin = nil
+		Ω(sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)).Should(BeFalse())
+		Ω(joinReq).Should(BeNil())
+	})
+
+	It("should fail if the join is not accepted", func() {
+		joinResp = map[string]any{"joined": false}
+		setup(pod, vaultPod("vault-0", sibling.URL))
+
+		joined, err := sut.joinRaft(ctx, logr.Discard(), cl, pod, vi, st)
+		Ω(err).Should(MatchError(ContainSubstring("could not join the raft cluster")))
+		Ω(joined).Should(BeFalse())
+	})
+})
+
+// vaultPod returns a running pod of the StatefulSet vault, with the vault address of the given server.
+func vaultPod(name, addr string) *corev1.Pod {
+	u, err := url.Parse(addr)
+	Ω(err).ShouldNot(HaveOccurred())
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "vault"}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: constants.ContainerNameVault,
+			Env:  []corev1.EnvVar{{Name: constants.EnvVaultAddr, Value: addr}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: u.Hostname()},
+	}
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// sealPollTick is the interval in which the poller checks whether the seal check of a StatefulSet is due.
+var sealPollTick = 10 * time.Second
+
+// SealPoller periodically checks the seal status of the pods of the managed StatefulSets and enqueues sealed pods
+// into the PodReconciler. It notices vaults that are sealed while their pod keeps running, which causes no pod event.
+type SealPoller struct {
+	Pods  *PodReconciler
+	Cache cache.Cache
+	// Interval is the check interval of StatefulSets without an interval of their own, e.g. those configured by
+	// labeled Secrets. Defaults to 1m.
+	Interval time.Duration
+
+	startedMux sync.Mutex
+	started    bool
+	lastPoll   map[types.VaultKey]time.Time
+	// pods are the names of the checked pods per StatefulSet, to remove the sealed metric of removed pods.
+	pods map[types.VaultKey][]string
+}
+
+// SetupWithManager sets up the poller with the Manager.
+func (p *SealPoller) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(p)
+}
+
+func (p *SealPoller) Start(ctx context.Context) error {
+	p.startedMux.Lock()
+	if p.started {
+		p.startedMux.Unlock()
+		return errors.New("poller is already running")
+	}
+	p.started = true
+	p.startedMux.Unlock()
+
+	t := time.NewTicker(sealPollTick)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			p.poll(ctx, now)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// poll checks the pods of every StatefulSet whose check interval has passed since its last check.
+func (p *SealPoller) poll(ctx context.Context, now time.Time) {
+	l := log.FromContext(ctx)
+	if p.lastPoll == nil {
+		p.lastPoll = make(map[types.VaultKey]time.Time)
+	}
+
+	current := make(map[types.VaultKey]bool)
+	for _, key := range p.Cache.Vaults() {
+		if key.Kind != types.KindStatefulSet {
+			continue
+		}
+		vi := p.Cache.VaultInfoFor(key)
+		if vi == nil {
+			continue
+		}
+		current[key] = true
+
+		interval := cmp.Or(vi.SealCheckInterval, p.Interval, constants.DefaultSealCheckInterval)
+		if last, ok := p.lastPoll[key]; ok && now.Sub(last) < interval {
+			continue
+		}
+		p.lastPoll[key] = now
+
+		if err := p.pollStatefulSet(ctx, key, vi); err != nil {
+			l.WithValues("vault", key.String()).Error(err, "seal check failed")
+		}
+	}
+
+	// forget the StatefulSets that are no longer managed
+	for key := range p.lastPoll {
+		if !current[key] {
+			delete(p.lastPoll, key)
+			p.dropSealed(key, nil)
+		}
+	}
+}
+
+// pollStatefulSet enqueues the sealed pods of the StatefulSet into the PodReconciler.
+func (p *SealPoller) pollStatefulSet(ctx context.Context, key types.VaultKey, vi *types.VaultInfo) error {
+	pods, err := p.Pods.podsOf(ctx, key.Namespace, key.Name)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, client.ObjectKeyFromObject(pod).String())
+	}
+	p.dropSealed(key, names)
+
+	for _, pod := range pods {
+		if !p.sealed(ctx, pod, vi) {
+			continue
+		}
+		log.FromContext(ctx).Info("vault is sealed, enqueue pod", "namespace", pod.Namespace, "pod", pod.Name)
+		if err := p.Pods.enqueue(ctx, pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSealed removes the sealed metric of the previously checked pods of the StatefulSet, that are not in the
+// given list of pods anymore (e.g. after a scale down), and remembers the list for the next check.
+func (p *SealPoller) dropSealed(key types.VaultKey, pods []string) {
+	for _, name := range p.pods[key] {
+		if !slices.Contains(pods, name) {
+			metrics.Sealed.DeleteLabelValues(name)
+		}
+	}
+	if len(pods) == 0 {
+		delete(p.pods, key)
+		return
+	}
+	if p.pods == nil {
+		p.pods = make(map[types.VaultKey][]string)
+	}
+	p.pods[key] = pods
+}
+
+// sealed returns true if the vault of the pod is initialized and sealed.
+// Pods that can not be reached are not reported as sealed.
+func (p *SealPoller) sealed(ctx context.Context, pod *corev1.Pod, vi *types.VaultInfo) bool {
+	l := log.FromContext(ctx).WithValues("namespace", pod.Namespace, "pod", pod.Name)
+	addr := getVaultAddress(ctx, pod, p.Pods.VaultContainerName, p.Pods.AddrEnvVarName)
+	cl, err := newClient(addr, true, vi)
+	if err != nil {
+		l.V(1).Info("could not create the vault client", "error", err.Error())
+		return false
+	}
+	st, err := cl.System.SealStatus(ctx)
+	if err != nil {
+		l.V(1).Info("could not check the seal status", "error", err.Error())
+		return false
+	}
+	metrics.Sealed.WithLabelValues(client.ObjectKeyFromObject(pod).String()).Set(metrics.Bool(st.Data.Sealed))
+	return st.Data.Initialized && st.Data.Sealed
+}
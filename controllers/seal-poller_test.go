@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/hashicorp/vault-client-go/schema"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SealPoller", func() {
+	var (
+		ctx      context.Context
+		sut      *SealPoller
+		events   chan event.GenericEvent
+		sealed   *httptest.Server
+		unsealed *httptest.Server
+		vi       *types.VaultInfo
+		key      types.VaultKey
+	)
+
+	sealStatus := func(st schema.SealStatusResponse) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(st)
+		}))
+	}
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		events = make(chan event.GenericEvent, 10)
+		sealed = sealStatus(schema.SealStatusResponse{Initialized: true, Sealed: true})
+		unsealed = sealStatus(schema.SealStatusResponse{Initialized: true})
+		vi = &types.VaultInfo{StatefulSet: "vault", SealCheckInterval: time.Minute}
+		key = types.StatefulSetKey("default", "vault")
+	})
+
+	AfterEach(func() {
+		sealed.Close()
+		unsealed.Close()
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		c := cache.NewSimple(false)
+		c.SetVaultInfoFor(key, vi)
+		sut = &SealPoller{
+			Pods: &PodReconciler{
+				Client: fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+				Scheme: s,
+				Cache:  c,
+				events: events,
+			},
+			Cache: c,
+		}
+	}
+
+	enqueued := func() []string {
+		var names []string
+		for {
+			select {
+			case e := <-events:
+				names = append(names, e.Object.GetName())
+			default:
+				return names
+			}
+		}
+	}
+
+	It("should enqueue the sealed pods", func() {
+		setup(vaultPod("vault-0", unsealed.URL), vaultPod("vault-1", sealed.URL), vaultPod("vault-2", sealed.URL))
+		sut.poll(ctx, time.Now())
+		Ω(enqueued()).Should(ConsistOf("vault-1", "vault-2"))
+	})
+
+	It("should check the pods again once the interval has passed", func() {
+		setup(vaultPod("vault-0", sealed.URL))
+		now := time.Now()
+		sut.poll(ctx, now)
+		Ω(enqueued()).Should(HaveLen(1))
+
+		sut.poll(ctx, now.Add(30*time.Second))
+		Ω(enqueued()).Should(BeEmpty())
+
+		sut.poll(ctx, now.Add(time.Minute))
+		Ω(enqueued()).Should(HaveLen(1))
+	})
+
+	It("should use the global interval for stateful sets without an interval", func() {
+		vi.SealCheckInterval = 0
+		setup(vaultPod("vault-0", sealed.URL))
+		sut.Interval = 5 * time.Minute
+		now := time.Now()
+		sut.poll(ctx, now)
+		Ω(enqueued()).Should(HaveLen(1))
+
+		sut.poll(ctx, now.Add(time.Minute))
+		Ω(enqueued()).Should(BeEmpty())
+
+		sut.poll(ctx, now.Add(5*time.Minute))
+		Ω(enqueued()).Should(HaveLen(1))
+	})
+
+	It("should remove the sealed metric of removed pods", func() {
+		setup(vaultPod("vault-0", sealed.URL), vaultPod("vault-1", sealed.URL))
+		now := time.Now()
+		sut.poll(ctx, now)
+		Ω(enqueued()).Should(HaveLen(2))
+
+		Ω(sut.Pods.Client.Delete(ctx, vaultPod("vault-1", sealed.URL))).Should(Succeed())
+		sut.poll(ctx, now.Add(time.Minute))
+		Ω(metrics.Sealed.DeleteLabelValues("default/vault-1")).Should(BeFalse())
+		Ω(metrics.Sealed.DeleteLabelValues("default/vault-0")).Should(BeTrue())
+
+		sut.Cache.DeleteVaultInfoFor(key)
+		sut.poll(ctx, now.Add(2*time.Minute))
+		Ω(sut.pods).Should(BeEmpty())
+	})
+
+	It("should not check pods of vaults that are not managed", func() {
+		setup(vaultPod("vault-0", sealed.URL))
+		sut.Cache.DeleteVaultInfoFor(key)
+		sut.Cache.SetVaultInfoFor(types.ExternalKey("default", "vault"), vi)
+		sut.poll(ctx, time.Now())
+		Ω(enqueued()).Should(BeEmpty())
+		Ω(sut.lastPoll).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// SecretReconciler reconciles the labeled unseal Secrets.
+type SecretReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Cache    cache.Cache
+	External *ExternalHandler
+	Pods     *PodReconciler
+	// Decrypter decrypts the unseal keys of the Secrets, if they are encrypted.
+	Decrypter keysource.Decrypter
+	// Recorder records the configuration errors of the Secrets.
+	Recorder events.EventRecorder
+	// Health tracks the loaded configurations and the running reconciles.
+	Health *Health
+
+	owner cacheOwner
+}
+
+// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch
+
+// Reconcile reconciles the Secret object.
+func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	ctx, done := r.Health.begin(ctx, configName(configKindSecret, req.NamespacedName))
+	defer done()
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, req.NamespacedName, secret)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			r.owner.release(req.NamespacedName, r.Cache, r.External)
+			r.Health.forget(configKindSecret, req.NamespacedName)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the req.
+		l.Error(err, "Error reading secret")
+		return reconcile.Result{}, err
+	}
+
+	if !secret.DeletionTimestamp.IsZero() || !isUnsealSecret(secret) {
+		r.owner.release(req.NamespacedName, r.Cache, r.External)
+		r.Health.forget(configKindSecret, req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	managed, err := r.isManaged(ctx, secret)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if managed {
+		// the configuration is handled by the VaultUnsealer controller
+		r.owner.release(req.NamespacedName, r.Cache, r.External)
+		r.Health.forget(configKindSecret, req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	var entry ownedEntry
+	sts, ok := secret.Labels[constants.LabelStatefulSetName]
+	if ok {
+		vi, err := extractVaultInfo(*secret)
+		if err == nil {
+			err = decryptUnsealKeys(r.Decrypter, vi)
+		}
+		if err != nil {
+			l.Error(err, "invalid unseal secret")
+			r.configurationError(secret, err)
+			r.Health.reconciled(configKindSecret, req.NamespacedName)
+			return reconcile.Result{}, err
+		}
+		vi.StatefulSet = sts
+		entry = ownedEntry{key: types.StatefulSetKey(secret.Namespace, sts), info: vi}
+	} else {
+		entry = ownedEntry{key: types.ExternalKey(secret.Namespace, secret.Name), version: secret.ResourceVersion}
+	}
+
+	if prev, ok := r.owner.get(req.NamespacedName); ok && !prev.sameTarget(entry) {
+		// the target changed, remove the previous entry
+		r.owner.release(req.NamespacedName, r.Cache, r.External)
+	}
+
+	if entry.key.Kind == types.KindExternal {
+		vi, err := r.External.applySecret(ctx, *secret)
+		r.Health.reconciled(configKindSecret, req.NamespacedName)
+		if err != nil {
+			l.Error(err, "invalid external configuration")
+			r.configurationError(secret, err)
+			return reconcile.Result{}, err
+		}
+		entry.info = vi
+		r.owner.set(req.NamespacedName, entry)
+		l.Info("external unseal secret applied")
+		return reconcile.Result{}, nil
+	}
+
+	setVaultInfo(r.Cache, entry.key, entry.info)
+	r.owner.set(req.NamespacedName, entry)
+	r.Health.reconciled(configKindSecret, req.NamespacedName)
+	l.WithValues("stateful-set", sts).Info("unseal secret applied")
+	return reconcile.Result{}, r.Pods.EnqueueStatefulSet(ctx, secret.Namespace, sts)
+}
+
+// configurationError records a warning event with the error of the configuration of the Secret.
+func (r *SecretReconciler) configurationError(secret *corev1.Secret, err error) {
+	recordEvent(r.Recorder, secret, corev1.EventTypeWarning, reasonConfigurationError, actionApply, err.Error())
+}
+
+// isManaged checks if the Secret is referenced by a VaultUnsealer.
+func (r *SecretReconciler) isManaged(ctx context.Context, secret *corev1.Secret) (bool, error) {
+	list := &v1alpha1.VaultUnsealerList{}
+	if err := r.List(ctx, list, client.InNamespace(secret.Namespace)); err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(list.Items, func(vu v1alpha1.VaultUnsealer) bool {
+		return slices.Contains(vu.SecretNames(), secret.Name)
+	}), nil
+}
+
+// secretsForUnsealer maps a VaultUnsealer to the Secrets it references.
+func (*SecretReconciler) secretsForUnsealer(_ context.Context, obj client.Object) []reconcile.Request {
+	vu, ok := obj.(*v1alpha1.VaultUnsealer)
+	if !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, name := range vu.SecretNames() {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: client.ObjectKey{Namespace: vu.Namespace, Name: name},
+		})
+	}
+	return requests
+}
+
+// isUnsealSecret checks if the Secret has one of the unsealer labels.
+func isUnsealSecret(obj client.Object) bool {
+	l := obj.GetLabels()
+	_, sts := l[constants.LabelStatefulSetName]
+	_, ext := l[constants.LabelExternal]
+	return sts || ext
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool {
+				return isUnsealSecret(e.Object)
+			},
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				// also handle removed labels to release the configuration
+				return isUnsealSecret(e.ObjectOld) || isUnsealSecret(e.ObjectNew)
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return isUnsealSecret(e.Object)
+			},
+			GenericFunc: func(e event.GenericEvent) bool {
+				return isUnsealSecret(e.Object)
+			},
+		})).
+		Watches(&v1alpha1.VaultUnsealer{}, handler.EnqueueRequestsFromMapFunc(r.secretsForUnsealer)).
+		Complete(r)
+}
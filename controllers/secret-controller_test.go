@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SecretReconciler", func() {
+	var (
+		sut    *SecretReconciler
+		ctx    context.Context
+		secret *corev1.Secret
+		req    ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "unseal",
+				Namespace: "default",
+				Labels:    map[string]string{constants.LabelStatefulSetName: "vault"},
+			},
+			Data: map[string][]byte{
+				constants.KeyPrefixUnsealKey + "1": []byte("foo"),
+				constants.KeyPrefixUnsealKey + "2": []byte("bar"),
+			},
+		}
+		req = ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secret)}
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		Ω(v1alpha1.AddToScheme(s)).ShouldNot(HaveOccurred())
+		c := cache.NewSimple(false)
+		sut = &SecretReconciler{
+			Client:   fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+			Scheme:   s,
+			Cache:    c,
+			External: &ExternalHandler{Cache: c},
+		}
+	}
+
+	It("should add the vault info of a stateful set secret to the cache", func() {
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.StatefulSet).Should(Equal("vault"))
+		Ω(vi.UnsealKeys).Should(ConsistOf("foo", "bar"))
+	})
+
+	It("should derive the approle auth method from the secret keys", func() {
+		secret.Data = map[string][]byte{
+			constants.KeySecretPath: []byte("secret/unseal"),
+			constants.KeyRoleID:     []byte("role-id"),
+			constants.KeySecretID:   []byte("secret-id"),
+			constants.KeyMountPath:  []byte("automation"),
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodAppRole))
+		Ω(vi.MountPath).Should(Equal("automation"))
+	})
+
+	It("should derive the cert auth method from the secret keys", func() {
+		secret.Data = map[string][]byte{
+			constants.KeySecretPath: []byte("secret/unseal"),
+			constants.KeyClientCert: []byte("cert"),
+			constants.KeyClientKey:  []byte("key"),
+			constants.KeyRole:       []byte("unsealer"),
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodCert))
+		Ω(vi.ClientCert).Should(Equal("cert"))
+		Ω(vi.ClientKey).Should(Equal("key"))
+	})
+
+	It("should not fall back to the tls keys if the client certificate is incomplete", func() {
+		secret.Data = map[string][]byte{
+			constants.KeySecretPath: []byte("secret/unseal"),
+			constants.KeyClientCert: []byte("cert"),
+			"clientkey":             []byte("key"),
+			corev1.TLSCertKey:       []byte("other-cert"),
+			corev1.TLSPrivateKeyKey: []byte("other-key"),
+			constants.KeyRole:       []byte("unsealer"),
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring(`must contain both "clientCert" and "clientKey"`)))
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should reject an invalid token expiration", func() {
+		secret.Data = map[string][]byte{
+			constants.KeySecretPath:      []byte("secret/unseal"),
+			constants.KeyAuthMethod:      []byte(constants.AuthMethodKubernetes),
+			constants.KeyRole:            []byte("unsealer"),
+			constants.KeyTokenExpiration: []byte("one hour"),
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring(`invalid "tokenExpiration"`)))
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should read the unseal keys of the init output", func() {
+		secret.Data = map[string][]byte{
+			constants.KeyInitJSON: []byte(`{"unseal_keys_b64": ["foo", "bar"], "root_token": "hvs.root"}`),
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(Equal([]string{"foo", "bar"}))
+	})
+
+	It("should fail for an invalid init output", func() {
+		secret.Data = map[string][]byte{constants.KeyInitJSON: []byte("{")}
+		setup(secret)
+		rec := events.NewFakeRecorder(1)
+		sut.Recorder = rec
+		sut.Health = &Health{}
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring("could not parse init.json")))
+		Ω(<-rec.Events).Should(And(HavePrefix("Warning ConfigurationError "), ContainSubstring("could not parse init.json")))
+		Ω(sut.Health.configs).Should(HaveKeyWithValue("Secret default/unseal", true))
+	})
+
+	It("should decrypt encrypted unseal keys", func() {
+		secret.Data = map[string][]byte{
+			constants.KeyPrefixUnsealKey + "1": []byte("enc:foo"),
+			constants.KeyPrefixUnsealKey + "2": []byte("enc:bar"),
+		}
+		setup(secret)
+		sut.Decrypter = prefixDecrypter("enc:")
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(ConsistOf("foo", "bar"))
+	})
+
+	It("should fail if the unseal keys can not be decrypted", func() {
+		setup(secret)
+		sut.Decrypter = prefixDecrypter("enc:")
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring("could not decrypt the unseal keys")))
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should update the cache if the secret changes", func() {
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		secret.Data[constants.KeyPrefixUnsealKey+"3"] = []byte("baz")
+		Ω(sut.Update(ctx, secret)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault")).UnsealKeys).Should(ConsistOf("foo", "bar", "baz"))
+	})
+
+	It("should move the cache entry if the stateful set label changes", func() {
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		secret.Labels[constants.LabelStatefulSetName] = "other"
+		Ω(sut.Update(ctx, secret)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "other"))).ShouldNot(BeNil())
+	})
+
+	It("should purge the keys if the secret is deleted", func() {
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Delete(ctx, secret)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should purge the keys if the label is removed", func() {
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		secret.Labels = nil
+		Ω(sut.Update(ctx, secret)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should skip secrets referenced by a vault unsealer", func() {
+		vu := &v1alpha1.VaultUnsealer{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "default"},
+			Spec: v1alpha1.VaultUnsealerSpec{
+				StatefulSet: "vault",
+				KeySource:   v1alpha1.KeySource{SecretRef: &corev1.LocalObjectReference{Name: secret.Name}},
+			},
+		}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should register an external check loop", func() {
+		secret.Labels = map[string]string{constants.LabelExternal: "1m"}
+		secret.Annotations = map[string]string{
+			constants.AnnotationExternalSource:  "https://vault.bakito.org:8200",
+			constants.AnnotationExternalTargets: "https://vault-1.bakito.org:8200",
+		}
+		setup(secret)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.ExternalKey("default", secret.Name))).ShouldNot(BeNil())
+		Ω(sut.External.configs).Should(HaveKey(types.ExternalKey("default", secret.Name)))
+
+		Ω(sut.Delete(ctx, secret)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.ExternalKey("default", secret.Name))).Should(BeNil())
+		Ω(sut.External.configs).ShouldNot(HaveKey(types.ExternalKey("default", secret.Name)))
+	})
+
+	It("should map a vault unsealer to its secrets", func() {
+		setup()
+		vu := &v1alpha1.VaultUnsealer{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "default"},
+			Spec: v1alpha1.VaultUnsealerSpec{
+				KeySource: v1alpha1.KeySource{SecretRef: &corev1.LocalObjectReference{Name: secret.Name}},
+			},
+		}
+		Ω(sut.secretsForUnsealer(ctx, vu)).Should(ConsistOf(req))
+	})
+})
+
+// prefixDecrypter "decrypts" shares by removing its prefix.
+type prefixDecrypter string
+
+func (d prefixDecrypter) Decrypt(share string) (string, error) {
+	plain, ok := strings.CutPrefix(share, string(d))
+	if !ok {
+		return "", errors.New("not encrypted")
+	}
+	return plain, nil
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultTokenExpiration is the default lifetime of requested service account tokens.
+	// It is also the minimum lifetime accepted by the TokenRequest API.
+	defaultTokenExpiration = 10 * time.Minute
+	// tokenRefreshRatio is the part of the token lifetime after which a new token is requested.
+	tokenRefreshRatio = 0.8
+)
+
+// ServiceAccountTokens requests short-lived tokens of the unsealer service account through the TokenRequest API.
+// Tokens are reused until they reach 80% of their lifetime.
+type ServiceAccountTokens struct {
+	Client         client.Client
+	Namespace      string
+	ServiceAccount string
+
+	mux    sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	token     string
+	refreshAt time.Time
+}
+
+// Token returns a token with the given audience and lifetime.
+func (t *ServiceAccountTokens) Token(ctx context.Context, audience string, expiration time.Duration) (string, error) {
+	if t == nil || t.ServiceAccount == "" {
+		return "", errors.New("service account token requests are not configured")
+	}
+	expiration = max(expiration, defaultTokenExpiration)
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	key := audience + "/" + expiration.String()
+	if c, ok := t.tokens[key]; ok && time.Now().Before(c.refreshAt) {
+		return c.token, nil
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: t.Namespace, Name: t.ServiceAccount}}
+	tr := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{audience},
+			ExpirationSeconds: ptr.To(int64(expiration.Seconds())),
+		},
+	}
+	issued := time.Now()
+	if err := t.Client.SubResource("token").Create(ctx, sa, tr); err != nil {
+		return "", fmt.Errorf("could not request service account token: %w", err)
+	}
+
+	lifetime := tr.Status.ExpirationTimestamp.Sub(issued)
+	if t.tokens == nil {
+		t.tokens = make(map[string]cachedToken)
+	}
+	t.tokens[key] = cachedToken{
+		token:     tr.Status.Token,
+		refreshAt: issued.Add(time.Duration(float64(lifetime) * tokenRefreshRatio)),
+	}
+	return tr.Status.Token, nil
+}
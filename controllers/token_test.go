@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceAccountTokens", func() {
+	var (
+		ctx    context.Context
+		tokens *ServiceAccountTokens
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "unsealer", Namespace: "default"}}
+		tokens = &ServiceAccountTokens{
+			Client:         fake.NewClientBuilder().WithScheme(s).WithObjects(sa).Build(),
+			Namespace:      "default",
+			ServiceAccount: "unsealer",
+		}
+	})
+
+	It("should request and cache a token", func() {
+		token, err := tokens.Token(ctx, "vault", time.Minute)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token).ShouldNot(BeEmpty())
+		Ω(tokens.tokens).Should(HaveKey("vault/" + defaultTokenExpiration.String()))
+
+		tokens.tokens["vault/"+defaultTokenExpiration.String()] = cachedToken{
+			token:     "cached",
+			refreshAt: time.Now().Add(time.Minute),
+		}
+		token, err = tokens.Token(ctx, "vault", 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token).Should(Equal("cached"))
+	})
+
+	It("should request a new token when the cached one is due for refresh", func() {
+		tokens.tokens = map[string]cachedToken{
+			"vault/" + defaultTokenExpiration.String(): {token: "expired", refreshAt: time.Now().Add(-time.Second)},
+		}
+		token, err := tokens.Token(ctx, "vault", 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token).ShouldNot(Equal("expired"))
+	})
+
+	It("should fail if no service account is configured", func() {
+		var t *ServiceAccountTokens
+		_, err := t.Token(ctx, "vault", 0)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should read the token from the configured token path", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "token")
+		Ω(os.WriteFile(path, []byte("projected\n"), 0o600)).ShouldNot(HaveOccurred())
+
+		token, err := serviceAccountToken(ctx, &types.VaultInfo{TokenPath: path, Audience: "vault"}, tokens)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token).Should(Equal("projected"))
+	})
+})
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// transitCiphertextPrefix is the prefix of ciphertexts of the vault transit engine (vault:v<key version>:...).
+const transitCiphertextPrefix = "vault:v"
+
+// isTransitCiphertext returns true if the unseal key is encrypted with the transit engine.
+func isTransitCiphertext(key string) bool {
+	return strings.HasPrefix(key, transitCiphertextPrefix)
+}
+
+// unsealKeysFor returns the plaintext unseal keys of the VaultInfo.
+// Transit encrypted keys are decrypted with the transit engine of the transit vault, the plaintext keys are
+// only kept in memory until the vault is unsealed. Other keys are returned as they are.
+func unsealKeysFor(ctx context.Context, vi *types.VaultInfo, tokens *ServiceAccountTokens) ([]string, error) {
+	if !slices.ContainsFunc(vi.UnsealKeys, isTransitCiphertext) {
+		return vi.UnsealKeys, nil
+	}
+	if vi.TransitAddress == "" || vi.TransitKey == "" {
+		return nil, errors.New("transit encrypted unseal keys require a transit address and key")
+	}
+
+	cl, err := newClient(vi.TransitAddress, false, vi)
+	if err != nil {
+		return nil, err
+	}
+	_, err = login(ctx, cl, vi, tokens)
+	metrics.VaultTokenOperations.WithLabelValues(metrics.TokenLogin, metrics.Result(err)).Inc()
+	if err != nil {
+		return nil, fmt.Errorf("could not login to the transit vault: %w", err)
+	}
+	defer revokeToken(ctx, cl)
+
+	keys := make([]string, 0, len(vi.UnsealKeys))
+	for i, key := range vi.UnsealKeys {
+		if !isTransitCiphertext(key) {
+			keys = append(keys, key)
+			continue
+		}
+		plain, err := transitDecrypt(ctx, cl, vi, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt unseal key %d with transit key %q: %w", i+1, vi.TransitKey, err)
+		}
+		// a share stored hex encoded is the same share as its base64 encoding, it must only be sent once
+		keys = append(keys, keysource.Normalize(plain))
+	}
+	return keysource.Unique(keys), nil
+}
+
+// transitDecrypt decrypts the ciphertext with the transit key of the VaultInfo.
+func transitDecrypt(ctx context.Context, cl *vault.Client, vi *types.VaultInfo, ciphertext string) (string, error) {
+	var opts []vault.RequestOption
+	if vi.TransitMount != "" {
+		opts = append(opts, vault.WithMountPath(vi.TransitMount))
+	}
+	resp, err := cl.Secrets.TransitDecrypt(ctx, vi.TransitKey, schema.TransitDecryptRequest{Ciphertext: ciphertext}, opts...)
+	if err != nil {
+		return "", err
+	}
+	encoded, ok := resp.Data["plaintext"].(string)
+	if !ok {
+		return "", errors.New("the response contains no plaintext")
+	}
+	// the transit engine returns the plaintext base64 encoded
+	plain, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(plain)), nil
+}
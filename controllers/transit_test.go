@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transit", func() {
+	var (
+		ctx      context.Context
+		srv      *httptest.Server
+		vi       *types.VaultInfo
+		mux      sync.Mutex
+		requests []string
+	)
+
+	// calls returns the received requests as "path token".
+	calls := func() []string {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]string{}, requests...)
+	}
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		requests = nil
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mux.Lock()
+			requests = append(requests, r.URL.Path+" "+r.Header.Get("X-Vault-Token"))
+			mux.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			data := map[string]any{}
+			if strings.Contains(r.URL.Path, "/decrypt/") {
+				body := map[string]string{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				plain, ok := strings.CutPrefix(body["ciphertext"], "vault:v1:")
+				if !ok {
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"invalid ciphertext"}})
+					return
+				}
+				data["plaintext"] = base64.StdEncoding.EncodeToString([]byte(plain))
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": data,
+				"auth": map[string]any{"client_token": "token", "lease_duration": 60},
+			})
+		}))
+		vi = &types.VaultInfo{
+			Username:       "user",
+			Password:       "pass",
+			TransitAddress: srv.URL,
+			TransitKey:     "unseal",
+			UnsealKeys:     []string{"vault:v1:foo", "bar"},
+		}
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	It("should decrypt the transit encrypted keys", func() {
+		keys, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys).Should(Equal([]string{"foo", "bar"}))
+		Ω(vi.UnsealKeys).Should(Equal([]string{"vault:v1:foo", "bar"}))
+		Ω(calls()).Should(Equal([]string{
+			"/v1/auth/userpass/login/user ",
+			"/v1/transit/decrypt/unseal token",
+			"/v1/auth/token/revoke-self token",
+		}))
+	})
+
+	It("should remove keys decrypted to a share that is also stored in another encoding", func() {
+		vi.UnsealKeys = []string{"vault:v1:666f6f", "Zm9v", "bar"}
+		keys, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys).Should(Equal([]string{"Zm9v", "bar"}))
+	})
+
+	It("should use the configured transit mount", func() {
+		vi.TransitMount = "root-transit"
+		_, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(calls()).Should(ContainElement("/v1/root-transit/decrypt/unseal token"))
+	})
+
+	It("should not contact the transit vault for plaintext keys", func() {
+		vi.UnsealKeys = []string{"foo", "bar"}
+		keys, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(keys).Should(Equal([]string{"foo", "bar"}))
+		Ω(calls()).Should(BeEmpty())
+	})
+
+	It("should fail without a transit key", func() {
+		vi.TransitKey = ""
+		_, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).Should(MatchError(ContainSubstring("require a transit address and key")))
+	})
+
+	It("should fail if a key can not be decrypted", func() {
+		vi.UnsealKeys = []string{"vault:v2:foo"}
+		_, err := unsealKeysFor(ctx, vi, nil)
+		Ω(err).Should(MatchError(ContainSubstring("could not decrypt unseal key 1")))
+		Ω(calls()).Should(ContainElement("/v1/auth/token/revoke-self token"))
+	})
+})
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+)
+
+// maxUnsealAttempts is the maximum number of key combinations tried to unseal a vault.
+const maxUnsealAttempts = 20
+
+// errInsufficientKeys is returned if fewer unseal keys than required by the threshold of the vault are available.
+var errInsufficientKeys = errors.New("insufficient unseal keys")
+
+// errKeysRejected is returned if the vault could not be unsealed with any combination of the unseal keys.
+var errKeysRejected = errors.New("could not unseal the vault")
+
+// errDecryptKeys is returned if the encrypted unseal keys could not be decrypted.
+var errDecryptKeys = errors.New("could not decrypt the unseal keys")
+
+// unseal unseals the vault with the given seal status using the provided unseal keys.
+// The keys are submitted until the threshold is reached, the remaining keys are not sent to the vault.
+// A partial unseal progress is reset before the keys are submitted. If a share is rejected, the progress is reset
+// and the vault is unsealed with other combinations of the keys. The indexes (starting at 1) of the shares that were
+// rejected or prevented the unseal are returned, the keys themselves are never logged.
+// The target identifies the vault in logs and metrics.
+func unseal(
+	ctx context.Context,
+	cl *vault.Client,
+	target string,
+	st *schema.SealStatusResponse,
+	keys []string,
+) (_ []int, err error) {
+	l := log.FromContext(ctx).WithValues("target", target)
+	if !st.Sealed {
+		return nil, nil
+	}
+	defer func() { observeUnseal(target, err) }()
+
+	threshold := int(st.T)
+	metrics.UnsealThreshold.WithLabelValues(target).Set(float64(st.T))
+	metrics.UnsealProgress.WithLabelValues(target).Set(float64(st.Progress))
+	if len(keys) < threshold {
+		return nil, fmt.Errorf("%w: %d keys available, %d of %d keys are required", errInsufficientKeys, len(keys), st.T, st.N)
+	}
+
+	if st.Progress > 0 {
+		l.WithValues("progress", st.Progress).Info("resetting partial unseal progress")
+		if err := resetUnseal(ctx, cl, target); err != nil {
+			return nil, err
+		}
+	}
+
+	// rejected are the shares the vault refused, suspects the shares contained in every failed combination
+	rejected := make(map[int]bool)
+	var suspects map[int]bool
+	attempts := 0
+	for combo := range combinations(len(keys), threshold) {
+		if slices.ContainsFunc(combo, func(i int) bool { return rejected[i] }) {
+			continue
+		}
+		if attempts == maxUnsealAttempts {
+			break
+		}
+		attempts++
+
+		unsealed, failedAt, err := submitShares(ctx, cl, target, keys, combo)
+		if unsealed {
+			metrics.UnsealProgress.DeleteLabelValues(target)
+			metrics.UnsealThreshold.DeleteLabelValues(target)
+			for _, i := range combo {
+				delete(suspects, i)
+			}
+			bad := badShares(rejected, suspects)
+			if len(bad) > 0 {
+				l.WithValues("shares", bad).Info("unsealed vault without the rejected shares")
+			}
+			return bad, nil
+		}
+
+		var re *vault.ResponseError
+		if err != nil && (!errors.As(err, &re) || re.StatusCode != http.StatusBadRequest) {
+			// the vault could not be reached, the progress is reset on the next attempt
+			return badShares(rejected, suspects), fmt.Errorf("could not submit unseal key %d: %w", combo[failedAt]+1, err)
+		}
+
+		if err != nil && failedAt < threshold-1 {
+			// the share itself was refused (e.g. it is malformed)
+			l.WithValues("share", combo[failedAt]+1).Info("unseal key was rejected")
+			rejected[combo[failedAt]] = true
+		} else {
+			// the shares of the combination could not be combined to unseal the vault
+			l.WithValues("shares", oneBased(combo)).Info("unseal keys did not unseal the vault")
+			suspects = intersect(suspects, combo)
+		}
+		if err := resetUnseal(ctx, cl, target); err != nil {
+			return badShares(rejected, suspects), err
+		}
+	}
+
+	bad := badShares(rejected, suspects)
+	return bad, fmt.Errorf("%w with %d keys after %d attempts, rejected shares %v",
+		errKeysRejected, len(keys), attempts, bad)
+}
+
+// observeUnseal updates the metrics of the vault with the result of an unseal.
+func observeUnseal(target string, err error) {
+	metrics.UnsealAttempts.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		metrics.UnsealFailures.WithLabelValues(unsealFailureReason(err)).Inc()
+		return
+	}
+	metrics.Sealed.WithLabelValues(target).Set(0)
+}
+
+// unsealFailureReason returns the metrics reason of the unseal error.
+func unsealFailureReason(err error) string {
+	var re *vault.ResponseError
+	switch {
+	case errors.Is(err, errInsufficientKeys):
+		return metrics.ReasonInsufficientKeys
+	case errors.Is(err, errKeysRejected):
+		return metrics.ReasonKeysRejected
+	case errors.Is(err, errDecryptKeys):
+		return metrics.ReasonDecrypt
+	case errors.As(err, &re):
+		return metrics.ReasonVaultError
+	default:
+		return metrics.ReasonUnreachable
+	}
+}
+
+// submitShares submits the keys of the combination. It returns true if the vault is unsealed,
+// or the position in the combination of the share that was rejected.
+func submitShares(
+	ctx context.Context,
+	cl *vault.Client,
+	target string,
+	keys []string,
+	combo []int,
+) (unsealed bool, failedAt int, err error) {
+	l := log.FromContext(ctx).WithValues("target", target)
+	var progress int32
+	for pos, i := range combo {
+		resp, err := cl.System.Unseal(ctx, schema.UnsealRequest{Key: keys[i]})
+		metrics.UnsealKeysSubmitted.WithLabelValues(metrics.Result(err)).Inc()
+		if err != nil {
+			return false, pos, err
+		}
+		if !resp.Data.Sealed {
+			l.WithValues("keys", pos+1).Info("unseal threshold reached")
+			return true, 0, nil
+		}
+
+		if resp.Data.Progress == progress {
+			l.WithValues("share", i+1).Info("unseal key did not advance the unseal progress")
+		}
+		progress = resp.Data.Progress
+		metrics.UnsealProgress.WithLabelValues(target).Set(float64(progress))
+		l.WithValues("progress", progress, "threshold", resp.Data.T).Info("unseal key accepted")
+	}
+	return false, len(combo) - 1, nil
+}
+
+// resetUnseal discards the keys submitted to the vault so far.
+func resetUnseal(ctx context.Context, cl *vault.Client, target string) error {
+	if _, err := cl.System.Unseal(ctx, schema.UnsealRequest{Reset: true}); err != nil {
+		return fmt.Errorf("could not reset the unseal progress: %w", err)
+	}
+	metrics.UnsealProgress.WithLabelValues(target).Set(0)
+	return nil
+}
+
+// combinations yields the combinations of k indexes out of n in lexicographic order.
+func combinations(n, k int) iter.Seq[[]int] {
+	return func(yield func([]int) bool) {
+		if k <= 0 || k > n {
+			return
+		}
+		combo := make([]int, k)
+		for i := range combo {
+			combo[i] = i
+		}
+		for {
+			if !yield(slices.Clone(combo)) {
+				return
+			}
+			// advance the rightmost index that has not reached its maximum
+			i := k - 1
+			for i >= 0 && combo[i] == n-k+i {
+				i--
+			}
+			if i < 0 {
+				return
+			}
+			combo[i]++
+			for j := i + 1; j < k; j++ {
+				combo[j] = combo[j-1] + 1
+			}
+		}
+	}
+}
+
+// intersect returns the indexes of the set that are contained in the combination.
+// A nil set is handled as the set of all indexes.
+func intersect(set map[int]bool, combo []int) map[int]bool {
+	result := make(map[int]bool)
+	for _, i := range combo {
+		if set == nil || set[i] {
+			result[i] = true
+		}
+	}
+	return result
+}
+
+// badShares returns the sorted indexes (starting at 1) of the rejected and suspected shares.
+func badShares(rejected, suspects map[int]bool) []int {
+	bad := slices.Collect(maps.Keys(rejected))
+	for i := range suspects {
+		if !rejected[i] {
+			bad = append(bad, i)
+		}
+	}
+	slices.Sort(bad)
+	return oneBased(bad)
+}
+
+// oneBased converts the indexes to share numbers starting at 1.
+func oneBased(indexes []int) []int {
+	shares := make([]int, len(indexes))
+	for i, idx := range indexes {
+		shares[i] = idx + 1
+	}
+	return shares
+}
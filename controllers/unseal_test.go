@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSealedVault is a sealed vault, that is unsealed by threshold distinct valid keys.
+// Keys starting with '!' are malformed and refused, other invalid keys fail when the threshold is reached.
+type fakeSealedVault struct {
+	mux       sync.Mutex
+	threshold int
+	valid     []string
+	accepted  []string
+	submitted []string
+	resets    int
+}
+
+func (f *fakeSealedVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	req := schema.UnsealRequest{}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	badRequest := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{msg}})
+	}
+
+	switch {
+	case req.Reset:
+		f.resets++
+		f.accepted = nil
+	case strings.HasPrefix(req.Key, "!"):
+		f.submitted = append(f.submitted, req.Key)
+		badRequest("'key' must be a valid hex or base64 string")
+		return
+	default:
+		f.submitted = append(f.submitted, req.Key)
+		if !slices.Contains(f.accepted, req.Key) {
+			f.accepted = append(f.accepted, req.Key)
+		}
+		if len(f.accepted) == f.threshold {
+			if slices.ContainsFunc(f.accepted, func(k string) bool { return !slices.Contains(f.valid, k) }) {
+				f.accepted = nil
+				badRequest("failed to decrypt keys")
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(schema.UnsealResponse{
+		Sealed:   len(f.accepted) < f.threshold,
+		T:        int32(f.threshold),
+		N:        int32(len(f.valid)),
+		Progress: int32(len(f.accepted) % f.threshold),
+	})
+}
+
+var _ = Describe("unseal", func() {
+	var (
+		ctx  context.Context
+		fake *fakeSealedVault
+		srv  *httptest.Server
+		cl   *vault.Client
+		st   *schema.SealStatusResponse
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		fake = &fakeSealedVault{threshold: 3, valid: []string{"k1", "k2", "k3", "k4", "k5"}}
+		srv = httptest.NewServer(fake)
+		var err error
+		cl, err = newClient(srv.URL, false, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		st = &schema.SealStatusResponse{Initialized: true, Sealed: true, T: 3, N: 5}
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	It("should submit only the keys required by the threshold", func() {
+		Ω(unseal(ctx, cl, "vault-0", st, fake.valid)).Should(BeEmpty())
+		Ω(fake.submitted).Should(Equal([]string{"k1", "k2", "k3"}))
+		Ω(fake.resets).Should(BeZero())
+	})
+
+	It("should skip an unsealed vault", func() {
+		st.Sealed = false
+		Ω(unseal(ctx, cl, "vault-0", st, fake.valid)).Should(BeEmpty())
+		Ω(fake.submitted).Should(BeEmpty())
+	})
+
+	It("should fail fast if there are fewer keys than the threshold", func() {
+		_, err := unseal(ctx, cl, "vault-0", st, []string{"k1", "k2"})
+		Ω(err).Should(MatchError(errInsufficientKeys))
+		Ω(err).Should(MatchError(ContainSubstring("2 keys available, 3 of 5 keys are required")))
+		Ω(fake.submitted).Should(BeEmpty())
+	})
+
+	It("should reset a partial unseal progress first", func() {
+		st.Progress = 1
+		Ω(unseal(ctx, cl, "vault-0", st, fake.valid)).Should(BeEmpty())
+		Ω(fake.resets).Should(Equal(1))
+		Ω(fake.submitted).Should(Equal([]string{"k1", "k2", "k3"}))
+	})
+
+	It("should skip a rejected share", func() {
+		rejected, err := unseal(ctx, cl, "vault-0", st, []string{"k1", "!k2", "k3", "k4"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(rejected).Should(Equal([]int{2}))
+		Ω(fake.submitted).Should(Equal([]string{"k1", "!k2", "k1", "k3", "k4"}))
+		Ω(fake.resets).Should(Equal(1))
+	})
+
+	It("should identify a share that prevents the unseal", func() {
+		rejected, err := unseal(ctx, cl, "vault-0", st, []string{"k1", "wrong", "k3", "k4"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(rejected).Should(Equal([]int{2}))
+		Ω(fake.resets).Should(Equal(2))
+	})
+
+	It("should report the bad shares if the vault can not be unsealed", func() {
+		rejected, err := unseal(ctx, cl, "vault-0", st, []string{"k1", "wrong", "k3"})
+		Ω(err).Should(MatchError(ContainSubstring("could not unseal the vault with 3 keys after 1 attempts")))
+		Ω(rejected).Should(Equal([]int{1, 2, 3}))
+		Ω(err.Error()).ShouldNot(ContainSubstring("wrong"))
+	})
+
+	It("should fail if the vault can not be reached", func() {
+		srv.Close()
+		_, err := unseal(ctx, cl, "vault-0", st, fake.valid)
+		Ω(err).Should(MatchError(ContainSubstring("could not submit unseal key 1")))
+	})
+
+	It("should count the unseal attempts and failures", func() {
+		success := testutil.ToFloat64(metrics.UnsealAttempts.WithLabelValues(metrics.ResultSuccess))
+		insufficient := testutil.ToFloat64(metrics.UnsealFailures.WithLabelValues(metrics.ReasonInsufficientKeys))
+		metrics.Sealed.WithLabelValues("vault-0").Set(1)
+
+		_, err := unseal(ctx, cl, "vault-0", st, fake.valid)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(testutil.ToFloat64(metrics.UnsealAttempts.WithLabelValues(metrics.ResultSuccess))).Should(Equal(success + 1))
+		Ω(testutil.ToFloat64(metrics.Sealed.WithLabelValues("vault-0"))).Should(BeZero())
+
+		_, err = unseal(ctx, cl, "vault-0", st, []string{"k1"})
+		Ω(err).Should(HaveOccurred())
+		Ω(testutil.ToFloat64(metrics.UnsealFailures.WithLabelValues(metrics.ReasonInsufficientKeys))).
+			Should(Equal(insufficient + 1))
+	})
+
+	It("should classify the unseal failures", func() {
+		Ω(unsealFailureReason(fmt.Errorf("%w: 1 keys", errInsufficientKeys))).Should(Equal(metrics.ReasonInsufficientKeys))
+		Ω(unsealFailureReason(fmt.Errorf("%w with 3 keys", errKeysRejected))).Should(Equal(metrics.ReasonKeysRejected))
+		Ω(unsealFailureReason(fmt.Errorf("%w: bad key", errDecryptKeys))).Should(Equal(metrics.ReasonDecrypt))
+		Ω(unsealFailureReason(&vault.ResponseError{StatusCode: http.StatusForbidden})).Should(Equal(metrics.ReasonVaultError))
+		Ω(unsealFailureReason(errors.New("connection refused"))).Should(Equal(metrics.ReasonUnreachable))
+	})
+
+	It("should observe the latency of the vault api", func() {
+		samples := func() uint64 {
+			mfs, err := ctrlmetrics.Registry.Gather()
+			Ω(err).ShouldNot(HaveOccurred())
+			var n uint64
+			for _, mf := range mfs {
+				if mf.GetName() != "vault_unsealer_vault_api_request_duration_seconds" {
+					continue
+				}
+				for _, m := range mf.GetMetric() {
+					for _, l := range m.GetLabel() {
+						if l.GetName() == "operation" && l.GetValue() == "sys/unseal" {
+							n += m.GetHistogram().GetSampleCount()
+						}
+					}
+				}
+			}
+			return n
+		}
+		before := samples()
+		_, err := cl.System.Unseal(ctx, schema.UnsealRequest{Reset: true})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(samples()).Should(Equal(before + 1))
+	})
+})
+
+var _ = Describe("combinations", func() {
+	It("should yield all combinations in lexicographic order", func() {
+		Ω(slices.Collect(combinations(4, 3))).Should(Equal([][]int{{0, 1, 2}, {0, 1, 3}, {0, 2, 3}, {1, 2, 3}}))
+	})
+
+	It("should yield nothing if there are not enough indexes", func() {
+		Ω(slices.Collect(combinations(2, 3))).Should(BeEmpty())
+	})
+})
@@ -1,27 +1,91 @@
 package controllers
 
 import (
-	"strings"
+	"context"
+	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 
+	"github.com/bakito/vault-unsealer/pkg/cache"
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
 	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
-func extractVaultInfo(secret corev1.Secret) *types.VaultInfo {
+// extractVaultInfo builds the VaultInfo configured by the Secret.
+func extractVaultInfo(secret corev1.Secret) (*types.VaultInfo, error) {
 	v := &types.VaultInfo{
 		Username:   string(secret.Data[constants.KeyUsername]),
 		Password:   string(secret.Data[constants.KeyPassword]),
 		Role:       string(secret.Data[constants.KeyRole]),
 		MountPath:  string(secret.Data[constants.KeyMountPath]),
 		SecretPath: string(secret.Data[constants.KeySecretPath]),
+		AuthMethod: string(secret.Data[constants.KeyAuthMethod]),
+		RoleID:     string(secret.Data[constants.KeyRoleID]),
+		SecretID:   string(secret.Data[constants.KeySecretID]),
+		Audience:   string(secret.Data[constants.KeyAudience]),
+		TokenPath:  string(secret.Data[constants.KeyTokenPath]),
+
+		TransitAddress: string(secret.Data[constants.KeyTransitAddress]),
+		TransitKey:     string(secret.Data[constants.KeyTransitKey]),
+		TransitMount:   string(secret.Data[constants.KeyTransitMount]),
+	}
+	var err error
+	if v.ClientCert, v.ClientKey, err = clientCertificate(secret); err != nil {
+		return nil, err
 	}
 
-	for key, val := range secret.Data {
-		if strings.HasPrefix(key, constants.KeyPrefixUnsealKey) {
-			v.UnsealKeys = append(v.UnsealKeys, string(val))
+	if exp, ok := secret.Data[constants.KeyTokenExpiration]; ok {
+		if v.TokenExpiration, err = time.ParseDuration(string(exp)); err != nil {
+			return nil, fmt.Errorf("secret %q has an invalid %q: %w", secret.Name, constants.KeyTokenExpiration, err)
 		}
 	}
-	return v
+
+	src := &keysource.Secret{Namespace: secret.Namespace, Name: secret.Name, Data: secret.Data}
+	keys, err := src.Keys(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not read the unseal keys from %s: %w", src, err)
+	}
+	v.UnsealKeys = keys
+	return v, nil
+}
+
+// decryptUnsealKeys decrypts the unseal keys of the VaultInfo in memory, if a Decrypter is configured.
+func decryptUnsealKeys(d keysource.Decrypter, vi *types.VaultInfo) error {
+	if d == nil || len(vi.UnsealKeys) == 0 {
+		return nil
+	}
+	keys, err := keysource.Decrypt(d, vi.UnsealKeys)
+	if err != nil {
+		return fmt.Errorf("could not decrypt the unseal keys: %w", err)
+	}
+	vi.UnsealKeys = keysource.Unique(keys)
+	return nil
+}
+
+// clientCertificate returns the client certificate and key for cert auth.
+// The keys of kubernetes.io/tls secrets are only used if the secret provides neither clientCert nor clientKey.
+func clientCertificate(secret corev1.Secret) (cert, key string, err error) {
+	c, okCert := secret.Data[constants.KeyClientCert]
+	k, okKey := secret.Data[constants.KeyClientKey]
+	switch {
+	case okCert && okKey:
+		return string(c), string(k), nil
+	case okCert || okKey:
+		return "", "", fmt.Errorf("secret %q must contain both %q and %q", secret.Name, constants.KeyClientCert,
+			constants.KeyClientKey)
+	}
+	return string(secret.Data[corev1.TLSCertKey]), string(secret.Data[corev1.TLSPrivateKeyKey]), nil
+}
+
+// setVaultInfo stores the VaultInfo in the cache.
+// Unseal keys already read from vault are kept, if the new VaultInfo does not provide any.
+func setVaultInfo(c cache.Cache, key types.VaultKey, vi *types.VaultInfo) {
+	if existing := c.VaultInfoFor(key); existing != nil &&
+		len(vi.UnsealKeys) == 0 && existing.SecretPath == vi.SecretPath {
+		vi.UnsealKeys = existing.UnsealKeys
+	}
+	vi.Key = key
+	c.SetVaultInfoFor(key, vi)
 }
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bakito/vault-unsealer/pkg/metrics"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// vaultTokenCheckInterval is the interval in which kept vault tokens are checked for renewal.
+var vaultTokenCheckInterval = 30 * time.Second
+
+// VaultTokens manages the lifecycle of the vault tokens used to read the unseal keys.
+// By default, a token is revoked after the keys are read. If Renew is set, one token per vault is kept
+// and renewed at half of its ttl, until it can't be renewed anymore.
+type VaultTokens struct {
+	Renew bool
+
+	mux    sync.Mutex
+	tokens map[types.VaultKey]*vaultToken
+}
+
+// vaultToken is a vault token kept for renewal.
+type vaultToken struct {
+	client      *vault.Client
+	token       string
+	credentials uint64
+	issued      time.Time
+	renewable   bool
+	// expires and renewAt are zero for tokens without ttl.
+	expires time.Time
+	renewAt time.Time
+}
+
+// setTTL updates the expiry of the token with the lease duration in seconds.
+func (t *vaultToken) setTTL(now time.Time, leaseDuration int, renewable bool) {
+	t.renewable = renewable
+	if leaseDuration <= 0 {
+		t.expires, t.renewAt = time.Time{}, time.Time{}
+		return
+	}
+	ttl := time.Duration(leaseDuration) * time.Second
+	t.expires = now.Add(ttl)
+	t.renewAt = now.Add(ttl / 2)
+}
+
+// valid returns true if the token has not expired at the given time.
+func (t *vaultToken) valid(now time.Time) bool {
+	return t.expires.IsZero() || now.Before(t.expires)
+}
+
+// login sets a token for the vault of the given key on the client.
+// If tokens are renewed, a kept token is reused as long as the credentials did not change.
+func (v *VaultTokens) login(
+	ctx context.Context,
+	cl *vault.Client,
+	key types.VaultKey,
+	vi *types.VaultInfo,
+	tokens *ServiceAccountTokens,
+) error {
+	creds := credentialsHash(vi)
+	if v != nil && v.Renew {
+		if t := v.kept(key); t != nil && t.credentials == creds && t.valid(time.Now()) {
+			return cl.SetToken(t.token)
+		}
+	}
+
+	auth, err := login(ctx, cl, vi, tokens)
+	metrics.VaultTokenOperations.WithLabelValues(metrics.TokenLogin, metrics.Result(err)).Inc()
+	if err != nil {
+		return err
+	}
+
+	if v != nil && v.Renew {
+		now := time.Now()
+		t := &vaultToken{client: cl, token: auth.ClientToken, credentials: creds, issued: now}
+		t.setTTL(now, auth.LeaseDuration, auth.Renewable)
+		v.keep(key, t)
+	}
+	return nil
+}
+
+// release revokes the token of the client, if tokens are not kept for renewal.
+func (v *VaultTokens) release(ctx context.Context, cl *vault.Client) {
+	if v != nil && v.Renew {
+		return
+	}
+	revokeToken(ctx, cl)
+}
+
+// revokeToken revokes the token of the client.
+func revokeToken(ctx context.Context, cl *vault.Client) {
+	_, err := cl.Auth.TokenRevokeSelf(ctx)
+	metrics.VaultTokenOperations.WithLabelValues(metrics.TokenRevoke, metrics.Result(err)).Inc()
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not revoke vault token")
+	}
+}
+
+// kept returns the kept token of the given vault.
+func (v *VaultTokens) kept(key types.VaultKey) *vaultToken {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	return v.tokens[key]
+}
+
+// keep stores the token of the given vault for renewal.
+func (v *VaultTokens) keep(key types.VaultKey, t *vaultToken) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if v.tokens == nil {
+		v.tokens = make(map[types.VaultKey]*vaultToken)
+	}
+	v.tokens[key] = t
+	metrics.VaultTokenAge.WithLabelValues(key.String()).Set(0)
+}
+
+// forget drops the kept token of the given vault.
+func (v *VaultTokens) forget(key types.VaultKey) {
+	delete(v.tokens, key)
+	metrics.VaultTokenAge.DeleteLabelValues(key.String())
+}
+
+// SetupWithManager adds the token renewal to the Manager, if tokens are renewed.
+func (v *VaultTokens) SetupWithManager(mgr ctrl.Manager) error {
+	if !v.Renew {
+		return nil
+	}
+	return mgr.Add(v)
+}
+
+// Start renews the kept tokens until the context is done.
+func (v *VaultTokens) Start(ctx context.Context) error {
+	ticker := time.NewTicker(vaultTokenCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			v.renewTokens(ctx, time.Now())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable, tokens are renewed on every instance.
+func (*VaultTokens) NeedLeaderElection() bool {
+	return false
+}
+
+// renewTokens renews the kept tokens that reached half of their ttl.
+// Tokens that can't be renewed are dropped, to be replaced by a new login.
+func (v *VaultTokens) renewTokens(ctx context.Context, now time.Time) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	for key, t := range v.tokens {
+		l := log.FromContext(ctx).WithValues("vault", key.String())
+		if !t.valid(now) {
+			l.Info("vault token expired")
+			v.forget(key)
+			continue
+		}
+		metrics.VaultTokenAge.WithLabelValues(key.String()).Set(now.Sub(t.issued).Seconds())
+		if t.renewAt.IsZero() || now.Before(t.renewAt) || !t.renewable {
+			continue
+		}
+
+		resp, err := t.client.Auth.TokenRenewSelf(ctx, schema.TokenRenewSelfRequest{},
+			vault.WithToken(t.token))
+		metrics.VaultTokenOperations.WithLabelValues(metrics.TokenRenew, metrics.Result(err)).Inc()
+		if err != nil || resp.Auth == nil {
+			l.Error(err, "could not renew vault token")
+			v.forget(key)
+			continue
+		}
+		t.setTTL(now, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	}
+}
+
+// credentialsHash returns a hash of the auth settings of the VaultInfo.
+// A kept token is not reused, if the credentials change.
+func credentialsHash(vi *types.VaultInfo) uint64 {
+	h := fnv.New64a()
+	for _, s := range []string{
+		vi.LoginMethod(), vi.Username, vi.Password, vi.Role, vi.MountPath, vi.RoleID, vi.SecretID,
+		vi.Audience, vi.TokenPath, vi.ClientCert, vi.ClientKey,
+	} {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VaultTokens", func() {
+	var (
+		ctx      context.Context
+		srv      *httptest.Server
+		cl       *vault.Client
+		vi       *types.VaultInfo
+		key      types.VaultKey
+		mux      sync.Mutex
+		requests []string
+	)
+
+	// calls returns the received requests as "path token".
+	calls := func() []string {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]string{}, requests...)
+	}
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		requests = nil
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mux.Lock()
+			requests = append(requests, r.URL.Path+" "+r.Header.Get("X-Vault-Token"))
+			mux.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{},
+				"auth": map[string]any{"client_token": "token", "lease_duration": 60, "renewable": true},
+			})
+		}))
+		var err error
+		cl, err = newClient(srv.URL, false, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		vi = &types.VaultInfo{Username: "user", Password: "pass"}
+		key = types.ExternalKey("default", "vault")
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	It("should revoke the token after it was used", func() {
+		var v *VaultTokens
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		v.release(ctx, cl)
+		Ω(calls()).Should(Equal([]string{
+			"/v1/auth/userpass/login/user ",
+			"/v1/auth/token/revoke-self token",
+		}))
+	})
+
+	It("should keep and reuse the token", func() {
+		v := &VaultTokens{Renew: true}
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		v.release(ctx, cl)
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		Ω(calls()).Should(Equal([]string{"/v1/auth/userpass/login/user "}))
+		Ω(v.kept(key)).ShouldNot(BeNil())
+	})
+
+	It("should login again if the credentials change", func() {
+		v := &VaultTokens{Renew: true}
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		vi.Password = "changed"
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		Ω(calls()).Should(HaveLen(2))
+	})
+
+	It("should renew the token at half of its ttl", func() {
+		v := &VaultTokens{Renew: true}
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+
+		v.renewTokens(ctx, time.Now().Add(10*time.Second))
+		Ω(calls()).Should(HaveLen(1))
+
+		v.renewTokens(ctx, time.Now().Add(40*time.Second))
+		Ω(calls()).Should(ContainElement("/v1/auth/token/renew-self token"))
+		Ω(v.kept(key).expires).Should(BeTemporally(">", time.Now().Add(90*time.Second)))
+	})
+
+	It("should drop expired tokens", func() {
+		v := &VaultTokens{Renew: true}
+		Ω(v.login(ctx, cl, key, vi, nil)).ShouldNot(HaveOccurred())
+		v.renewTokens(ctx, time.Now().Add(2*time.Minute))
+		Ω(v.kept(key)).Should(BeNil())
+	})
+})
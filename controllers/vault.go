@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -12,6 +13,8 @@ import (
 	"github.com/hashicorp/vault-client-go/schema"
 
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
@@ -19,152 +22,209 @@ import (
 const defaultK8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token" // #nosec G101 not a secret
 
 // newClient creates a new Vault client with the specified address.
-func newClient(address string, insecureSkipVerify bool) (*vault.Client, error) {
-	return vault.New(
+// The client certificate of the VaultInfo is used for TLS, if one is configured.
+func newClient(address string, insecureSkipVerify bool, vi *types.VaultInfo) (*vault.Client, error) {
+	return newTLSClient(address, withClientCertificate(vault.TLSConfiguration{InsecureSkipVerify: insecureSkipVerify}, vi))
+}
+
+// withClientCertificate adds the client certificate and key of the VaultInfo to the TLS configuration.
+func withClientCertificate(tls vault.TLSConfiguration, vi *types.VaultInfo) vault.TLSConfiguration {
+	if vi != nil && vi.ClientCert != "" {
+		tls.ClientCertificate.FromBytes = []byte(vi.ClientCert)
+		tls.ClientCertificateKey.FromBytes = []byte(vi.ClientKey)
+	}
+	return tls
+}
+
+// newTLSClient creates a new Vault client with the specified address and TLS configuration.
+// The latency of its requests is observed by the vault api metrics, every request reports progress of the running
+// reconcile or check.
+func newTLSClient(address string, tls vault.TLSConfiguration) (*vault.Client, error) {
+	cl, err := vault.New(
 		vault.WithAddress(address),
 		vault.WithRequestTimeout(30*time.Second),
-		vault.WithTLS(vault.TLSConfiguration{InsecureSkipVerify: insecureSkipVerify}),
+		vault.WithTLS(tls),
 	)
+	if err != nil {
+		return nil, err
+	}
+	// the http client is created for each vault client, the TLS configuration is already applied to its transport
+	hc := cl.Configuration().HTTPClient
+	hc.Transport = progressTransport{next: metrics.InstrumentVaultAPI(hc.Transport)}
+	return cl, nil
 }
 
-func login(ctx context.Context, cl *vault.Client, vi *types.VaultInfo) error {
-	var token string
+// progressTransport reports progress of the task of the request context with every finished vault request,
+// so a slow but progressing unseal is not reported as wedged.
+type progressTransport struct {
+	next http.RoundTripper
+}
+
+func (t progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	reportProgress(req.Context())
+	return resp, err
+}
+
+// login authenticates the client with the auth method of the VaultInfo and returns the auth information of the token.
+func login(
+	ctx context.Context,
+	cl *vault.Client,
+	vi *types.VaultInfo,
+	tokens *ServiceAccountTokens,
+) (*vault.ResponseAuth, error) {
+	var auth *vault.ResponseAuth
 	var err error
 
-	if vi.Username != "" && vi.Password != "" {
-		token, err = userPassLogin(ctx, cl, vi.Username, vi.Password)
-	} else if strings.TrimSpace(vi.Role) != "" {
-		token, err = kubernetesLogin(ctx, cl, vi.Role, vi.MountPath)
+	switch vi.LoginMethod() {
+	case constants.AuthMethodUserpass:
+		auth, err = userPassLogin(ctx, cl, vi.Username, vi.Password)
+	case constants.AuthMethodKubernetes:
+		auth, err = kubernetesLogin(ctx, cl, vi, tokens)
+	case constants.AuthMethodJWT:
+		auth, err = jwtLogin(ctx, cl, vi, tokens)
+	case constants.AuthMethodAppRole:
+		auth, err = appRoleLogin(ctx, cl, vi.RoleID, vi.SecretID, vi.MountPath)
+	case constants.AuthMethodCert:
+		auth, err = certLogin(ctx, cl, vi.Role, vi.MountPath)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if token == "" {
-		return errors.New("no supported auth method is used")
+	if auth == nil || auth.ClientToken == "" {
+		return nil, errors.New("no supported auth method is used")
 	}
-	err = cl.SetToken(token)
+	err = cl.SetToken(auth.ClientToken)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return auth, nil
 }
 
 // userPassLogin performs authentication with Vault using username/password.
-func userPassLogin(ctx context.Context, cl *vault.Client, username, password string) (string, error) {
+func userPassLogin(ctx context.Context, cl *vault.Client, username, password string) (*vault.ResponseAuth, error) {
 	secret, err := cl.Auth.UserpassLogin(ctx, username, schema.UserpassLoginRequest{Password: password})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	token := secret.Auth.ClientToken
-	return token, nil
+	return secret.Auth, nil
 }
 
-// kubernetesLogin performs authentication with Vault using Kubernetes JWT.
-func kubernetesLogin(ctx context.Context, cl *vault.Client, role, mountPath string) (string, error) {
-	// Get the path to the Kubernetes service account token file.
-	tokenFile := defaultK8sTokenFile
+// appRoleLogin performs authentication with Vault using AppRole role_id and secret_id.
+func appRoleLogin(ctx context.Context, cl *vault.Client, roleID, secretID, mountPath string) (*vault.ResponseAuth, error) {
+	secret, err := cl.Auth.AppRoleLogin(
+		ctx,
+		schema.AppRoleLoginRequest{RoleId: roleID, SecretId: secretID},
+		vault.WithMountPath(mountPath),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Auth, nil
+}
 
-	// Check if the token file path is overridden in development mode.
-	if path, ok := constants.DevFlag(constants.EnvDevelopmentModeK8sTokenFile); ok {
-		tokenFile = path
+// certLogin performs authentication with Vault using the TLS client certificate of the client.
+// If no role name is given, vault tries all roles matching the certificate.
+func certLogin(ctx context.Context, cl *vault.Client, name, mountPath string) (*vault.ResponseAuth, error) {
+	secret, err := cl.Auth.CertLogin(ctx, schema.CertLoginRequest{Name: name}, vault.WithMountPath(mountPath))
+	if err != nil {
+		return nil, err
 	}
+	return secret.Auth, nil
+}
 
-	// Read the Kubernetes service account token from the file.
-	saToken, err := os.ReadFile(tokenFile)
+// kubernetesLogin performs authentication with Vault using Kubernetes JWT.
+func kubernetesLogin(
+	ctx context.Context,
+	cl *vault.Client,
+	vi *types.VaultInfo,
+	tokens *ServiceAccountTokens,
+) (*vault.ResponseAuth, error) {
+	saToken, err := serviceAccountToken(ctx, vi, tokens)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Authenticate with Vault using Kubernetes JWT.
 	secret, err := cl.Auth.KubernetesLogin(
 		ctx,
-		schema.KubernetesLoginRequest{Jwt: strings.TrimSpace(string(saToken)), Role: role},
-		vault.WithMountPath(mountPath),
+		schema.KubernetesLoginRequest{Jwt: saToken, Role: vi.Role},
+		vault.WithMountPath(vi.MountPath),
 	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	token := secret.Auth.ClientToken
-	return token, nil
+	return secret.Auth, nil
 }
 
-// readUnsealKeys reads the unseal keys from Vault for the given VaultInfo.
-func readUnsealKeys(ctx context.Context, cl *vault.Client, v *types.VaultInfo) error {
-	mounts, err := cl.System.MountsListSecretsEngines(ctx)
+// jwtLogin performs authentication with Vault using the jwt auth method and a service account token.
+func jwtLogin(
+	ctx context.Context,
+	cl *vault.Client,
+	vi *types.VaultInfo,
+	tokens *ServiceAccountTokens,
+) (*vault.ResponseAuth, error) {
+	saToken, err := serviceAccountToken(ctx, vi, tokens)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	mount, path := v.SecretMountAndPath()
-
-	var data map[string]any
-	var warnings []string
 
-	version := childOf[string](mounts.Data, mount+"/", "options", "version")
-	switch version {
-	case "1":
-		sec, err := cl.Secrets.KvV1Read(ctx, path, vault.WithMountPath(mount))
-		if err != nil {
-			return err
-		}
-		data = sec.Data
-		warnings = sec.Warnings
-	case "2":
-		sec, err := cl.Secrets.KvV2Read(ctx, path, vault.WithMountPath(mount))
-		if err != nil {
-			return err
-		}
-		data = sec.Data.Data
-		warnings = sec.Warnings
-	default:
-		return fmt.Errorf("unsupported kv version %q", version)
+	secret, err := cl.Auth.JwtLogin(
+		ctx,
+		schema.JwtLoginRequest{Jwt: saToken, Role: vi.Role},
+		vault.WithMountPath(vi.MountPath),
+	)
+	if err != nil {
+		return nil, err
 	}
+	return secret.Auth, nil
+}
 
-	if data == nil {
-		return fmt.Errorf("did not receive a valid secret with path %s", v.SecretPath)
+// serviceAccountToken returns the service account token used for kubernetes and jwt auth.
+// The token is read from the configured token path (e.g. a projected volume), requested with the configured
+// audience through the TokenRequest API, or read from the default service account token file.
+func serviceAccountToken(ctx context.Context, vi *types.VaultInfo, tokens *ServiceAccountTokens) (string, error) {
+	if vi.Audience != "" && vi.TokenPath == "" {
+		return tokens.Token(ctx, vi.Audience, vi.TokenExpiration)
 	}
 
-	if len(warnings) > 0 {
-		return errors.New(strings.Join(warnings, ","))
+	// Get the path to the Kubernetes service account token file.
+	tokenFile := defaultK8sTokenFile
+	if vi.TokenPath != "" {
+		tokenFile = vi.TokenPath
+	} else if path, ok := constants.DevFlag(constants.EnvDevelopmentModeK8sTokenFile); ok {
+		// Check if the token file path is overridden in development mode.
+		tokenFile = path
 	}
 
-	extractUnsealKeys(data, v)
-	return nil
-}
-
-// childOf retrieves a nested value from a map[string]any.
-func childOf[T any](m any, key ...string) T {
-	var empty T
-	if mm, ok := m.(map[string]any); ok {
-		if len(key) == 1 {
-			if t, ok := mm[key[0]].(T); ok {
-				return t
-			}
-			return empty
-		}
-		return childOf[T](mm[key[0]], key[1:]...)
-	}
-	return empty
+	// Read the Kubernetes service account token from the file.
+	saToken, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(saToken)), nil
 }
 
-// extractUnsealKeys extracts unseal keys from the secret data.
-func extractUnsealKeys(data map[string]any, v *types.VaultInfo) {
-	for k, val := range data {
-		if strings.HasPrefix(k, constants.KeyPrefixUnsealKey) {
-			v.UnsealKeys = append(v.UnsealKeys, fmt.Sprintf("%v", val))
-		}
+// readUnsealKeys reads the unseal keys from the key source of the given VaultInfo.
+func readUnsealKeys(ctx context.Context, cl *vault.Client, v *types.VaultInfo) error {
+	src := keySourceFor(cl, v)
+	if src == nil {
+		return errors.New("no key source to read the unseal keys from is configured")
+	}
+	keys, err := src.Keys(ctx)
+	if err != nil {
+		return fmt.Errorf("could not read the unseal keys from %s: %w", src, err)
 	}
+	v.UnsealKeys = keys
+	return nil
 }
 
-// unseal unseals the Vault using the provided unseal keys.
-func unseal(ctx context.Context, cl *vault.Client, vi *types.VaultInfo) error {
-	for _, key := range vi.UnsealKeys {
-		resp, err := cl.System.Unseal(ctx, schema.UnsealRequest{Key: key})
-		if err != nil {
-			return err
-		}
-		if !resp.Data.Sealed {
-			return nil
-		}
-	}
-	return errors.New("could not unseal vault")
+// keySourceFor returns the key source the unseal keys of the VaultInfo are read from.
+// The client must be authenticated, if the key source is a vault.
+func keySourceFor(cl *vault.Client, v *types.VaultInfo) keysource.KeySource {
+	if v.SecretPath != "" {
+		mount, path := v.SecretMountAndPath()
+		return &keysource.VaultKV{Client: cl, Mount: mount, Path: path}
+	}
+	return nil
 }
@@ -0,0 +1,466 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+const (
+	reasonConfigured         = "Configured"
+	reasonConfigurationError = "ConfigurationError"
+	reasonCheckFailed        = "CheckFailed"
+)
+
+// VaultUnsealerReconciler reconciles a VaultUnsealer object.
+type VaultUnsealerReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Cache    cache.Cache
+	External *ExternalHandler
+	Pods     *PodReconciler
+	Files    *KeyFileWatcher
+	// DisableSecrets disables reading and watching Secrets, for clusters where the unsealer has no access to them.
+	DisableSecrets bool
+	// Decrypter decrypts the unseal keys of Secrets and key files, if they are encrypted.
+	Decrypter keysource.Decrypter
+	// Health tracks the loaded configurations and the running reconciles.
+	Health *Health
+
+	owner cacheOwner
+}
+
+// +kubebuilder:rbac:groups=vault-unsealer.bakito.net,resources=vaultunsealers,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=vault-unsealer.bakito.net,resources=vaultunsealers/status,verbs=get;update;patch
+
+// Reconcile reconciles the VaultUnsealer object.
+func (r *VaultUnsealerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	ctx, done := r.Health.begin(ctx, configName(configKindVaultUnsealer, req.NamespacedName))
+	defer done()
+
+	vu := &v1alpha1.VaultUnsealer{}
+	err := r.Get(ctx, req.NamespacedName, vu)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			r.release(req.NamespacedName)
+			r.Health.forget(configKindVaultUnsealer, req.NamespacedName)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the req.
+		l.Error(err, "Error reading vault unsealer")
+		return reconcile.Result{}, err
+	}
+
+	if !vu.DeletionTimestamp.IsZero() {
+		r.release(req.NamespacedName)
+		r.Health.forget(configKindVaultUnsealer, req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	applyErr := r.apply(ctx, vu)
+	if applyErr != nil {
+		l.Error(applyErr, "Error applying vault unsealer configuration")
+	}
+	r.Health.reconciled(configKindVaultUnsealer, req.NamespacedName)
+	return r.updateStatus(ctx, vu, applyErr)
+}
+
+// apply updates the cache and the external check loops with the configuration of the VaultUnsealer.
+func (r *VaultUnsealerReconciler) apply(ctx context.Context, vu *v1alpha1.VaultUnsealer) error {
+	vi, err := r.vaultInfoFor(ctx, vu)
+	if err != nil {
+		return err
+	}
+
+	entry := ownedEntry{key: types.StatefulSetKey(vu.Namespace, vu.Spec.StatefulSet), info: vi}
+	var cfg *externalConfig
+	if vu.Spec.External != nil {
+		if cfg, err = r.externalConfigFor(ctx, vu, vi); err != nil {
+			return err
+		}
+		entry = ownedEntry{key: types.ExternalKey(vu.Namespace, vu.Name), info: vi, version: cfg.version}
+	} else if vu.Spec.StatefulSet == "" {
+		return errors.New("one of statefulSet or external must be set")
+	}
+
+	key := client.ObjectKeyFromObject(vu)
+	if prev, ok := r.owner.get(key); ok && !prev.sameTarget(entry) {
+		// the target changed, remove the previous entry
+		r.release(key)
+	}
+
+	setVaultInfo(r.Cache, entry.key, vi)
+	r.owner.set(key, entry)
+	if vi.KeyPath != "" {
+		r.Files.watch(key, vi.KeyPath)
+	} else {
+		r.Files.unwatch(key)
+	}
+
+	if cfg != nil {
+		r.External.apply(entry.key, cfg)
+		return nil
+	}
+	return r.Pods.EnqueueStatefulSet(ctx, vu.Namespace, vu.Spec.StatefulSet)
+}
+
+// release removes the cache entry, external check loop and key file watch managed by the given VaultUnsealer.
+func (r *VaultUnsealerReconciler) release(key client.ObjectKey) {
+	r.Files.unwatch(key)
+	r.owner.release(key, r.Cache, r.External)
+}
+
+// vaultInfoFor builds the VaultInfo described by the VaultUnsealer.
+func (r *VaultUnsealerReconciler) vaultInfoFor(ctx context.Context, vu *v1alpha1.VaultUnsealer) (*types.VaultInfo, error) {
+	vi := &types.VaultInfo{}
+	if ref := vu.Spec.KeySource.SecretRef; ref != nil {
+		secret, err := r.secretFor(ctx, vu, ref.Name)
+		switch {
+		case err == nil:
+			if vi, err = extractVaultInfo(*secret); err != nil {
+				return nil, err
+			}
+		case vu.Spec.Init != nil && kerrors.IsNotFound(err):
+			// the secret is created with the init output of the vault
+		default:
+			return nil, err
+		}
+	}
+	if vu.Spec.Init != nil {
+		if err := r.applyInitConfig(ctx, vu, vi); err != nil {
+			return nil, err
+		}
+	}
+	if order := vu.Spec.UnsealOrder; order != nil {
+		vi.UnsealOrder = string(order.Strategy)
+		vi.SettleDelay = constants.DefaultSettleDelay
+		if order.SettleDelay != nil {
+			vi.SettleDelay = order.SettleDelay.Duration
+		}
+		vi.ActiveTimeout = constants.DefaultActiveTimeout
+		if order.ActiveTimeout != nil {
+			vi.ActiveTimeout = order.ActiveTimeout.Duration
+		}
+	}
+	if vu.Spec.StatefulSet != "" && vu.Spec.Interval != nil && vu.Spec.Interval.Duration > 0 {
+		// without an interval, the seal check uses the global interval
+		vi.SealCheckInterval = vu.Spec.Interval.Duration
+	}
+	if join := vu.Spec.RaftJoin; join != nil {
+		vi.RaftJoin = &types.RaftJoinConfig{LeaderTLSServerName: join.LeaderTLSServerName}
+		if ref := join.LeaderCASecretRef; ref != nil {
+			ca, _, err := r.secretKeyFor(ctx, vu, "leader ca", ref)
+			if err != nil {
+				return nil, err
+			}
+			vi.RaftJoin.LeaderCACert = string(ca)
+		}
+	}
+	if vu.Spec.KeySource.VaultPath != "" {
+		vi.SecretPath = vu.Spec.KeySource.VaultPath
+	}
+	if path := vu.Spec.KeySource.Path; path != "" {
+		keys, err := (&keysource.File{Path: path}).Keys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the unseal keys from %q: %w", path, err)
+		}
+		vi.UnsealKeys = keysource.Unique(append(vi.UnsealKeys, keys...))
+		vi.KeyPath = path
+	}
+	if err := decryptUnsealKeys(r.Decrypter, vi); err != nil {
+		return nil, err
+	}
+
+	if auth := vu.Spec.Auth; auth != nil {
+		vi.AuthMethod = string(auth.Method)
+		if auth.Role != "" {
+			vi.Role = auth.Role
+		}
+		if auth.MountPath != "" {
+			vi.MountPath = auth.MountPath
+		}
+		if auth.Audience != "" {
+			vi.Audience = auth.Audience
+		}
+		if auth.TokenPath != "" {
+			vi.TokenPath = auth.TokenPath
+		}
+		if auth.TokenExpiration != nil {
+			vi.TokenExpiration = auth.TokenExpiration.Duration
+		}
+		if ref := auth.CredentialsSecretRef; ref != nil {
+			secret, err := r.secretFor(ctx, vu, ref.Name)
+			if err != nil {
+				return nil, err
+			}
+			vi.Username = string(secret.Data[constants.KeyUsername])
+			vi.Password = string(secret.Data[constants.KeyPassword])
+			vi.RoleID = string(secret.Data[constants.KeyRoleID])
+			vi.SecretID = string(secret.Data[constants.KeySecretID])
+			if vi.ClientCert, vi.ClientKey, err = clientCertificate(*secret); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(vi.UnsealKeys) == 0 && vi.SecretPath == "" && vi.KeyPath == "" && vi.Init == nil {
+		return nil, errors.New("key source provides neither unseal keys nor a vault secret path nor a key path")
+	}
+
+	vi.StatefulSet = vu.Spec.StatefulSet
+	return vi, nil
+}
+
+// applyInitConfig sets the init configuration of the VaultUnsealer to the VaultInfo
+// and adds the unseal keys of a previous initialization stored in the init secret.
+func (r *VaultUnsealerReconciler) applyInitConfig(ctx context.Context, vu *v1alpha1.VaultUnsealer, vi *types.VaultInfo) error {
+	init := vu.Spec.Init
+	ic := &types.InitConfig{
+		Shares:         init.Shares,
+		Threshold:      init.Threshold,
+		PGPKeys:        init.PGPKeys,
+		SecretName:     init.SecretName,
+		StoreRootToken: init.RootToken == v1alpha1.RootTokenStore,
+	}
+	if ic.Shares == 0 {
+		ic.Shares = constants.DefaultInitShares
+	}
+	if ic.Threshold == 0 {
+		ic.Threshold = min(constants.DefaultInitThreshold, ic.Shares)
+	}
+	if ic.Threshold > ic.Shares {
+		return fmt.Errorf("the init threshold %d must not be greater than the shares %d", ic.Threshold, ic.Shares)
+	}
+	if len(ic.PGPKeys) > 0 && len(ic.PGPKeys) != int(ic.Shares) {
+		return fmt.Errorf("init requires one pgp key per share, got %d keys for %d shares", len(ic.PGPKeys), ic.Shares)
+	}
+	if v := init.Vault; v != nil {
+		if mount, path, _ := strings.Cut(v.Path, "/"); mount == "" || path == "" {
+			return fmt.Errorf("the init vault path %q must be in the format <mount>/<path>", v.Path)
+		}
+		ic.VaultAddress = v.Address
+		ic.VaultPath = v.Path
+	}
+
+	ref := vu.Spec.KeySource.SecretRef
+	if ic.SecretName == "" && ref != nil {
+		ic.SecretName = ref.Name
+	}
+	if ic.SecretName != "" && r.DisableSecrets {
+		return fmt.Errorf("could not store the init output in secret %q: reading secrets is disabled", ic.SecretName)
+	}
+	if ic.SecretName == "" && ic.VaultAddress == "" {
+		return errors.New("init requires a secret or a vault to store the init output in")
+	}
+
+	if ic.SecretName != "" && (ref == nil || ref.Name != ic.SecretName) {
+		secret, err := r.secretFor(ctx, vu, ic.SecretName)
+		switch {
+		case err == nil:
+			stored, err := extractVaultInfo(*secret)
+			if err != nil {
+				return err
+			}
+			vi.UnsealKeys = keysource.Unique(append(vi.UnsealKeys, stored.UnsealKeys...))
+		case !kerrors.IsNotFound(err):
+			return err
+		}
+	}
+
+	vi.Init = ic
+	return nil
+}
+
+// externalConfigFor builds the external check loop configuration of the VaultUnsealer.
+func (r *VaultUnsealerReconciler) externalConfigFor(
+	ctx context.Context,
+	vu *v1alpha1.VaultUnsealer,
+	vi *types.VaultInfo,
+) (*externalConfig, error) {
+	tls := vault.TLSConfiguration{}
+	version := strconv.FormatInt(vu.Generation, 10)
+	if vu.Spec.TLS != nil {
+		tls.InsecureSkipVerify = vu.Spec.TLS.InsecureSkipVerify
+		if ref := vu.Spec.TLS.CASecretRef; ref != nil {
+			ca, secretVersion, err := r.secretKeyFor(ctx, vu, "ca", ref)
+			if err != nil {
+				return nil, err
+			}
+			tls.ServerCertificate.FromBytes = ca
+			// restart the loop if the ca certificate changes
+			version += "/" + secretVersion
+		}
+	}
+
+	if vi.ClientCert != "" {
+		// restart the loop if the client certificate changes
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(vi.ClientCert + vi.ClientKey))
+		version += fmt.Sprintf("/%x", h.Sum32())
+	}
+
+	// the client certificate authenticates against the source vault only
+	src, err := newTLSClient(vu.Spec.External.Source, withClientCertificate(tls, vi))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*vault.Client
+	for _, t := range vu.Spec.External.Targets {
+		cl, err := newTLSClient(t, tls)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, cl)
+	}
+
+	interval := constants.DefaultExternalInterval
+	if vu.Spec.Interval != nil && vu.Spec.Interval.Duration > 0 {
+		interval = vu.Spec.Interval.Duration
+	}
+
+	return &externalConfig{
+		version:  version,
+		interval: interval,
+		source:   src,
+		targets:  targets,
+		owner:    client.ObjectKeyFromObject(vu),
+	}, nil
+}
+
+func (r *VaultUnsealerReconciler) secretFor(
+	ctx context.Context,
+	vu *v1alpha1.VaultUnsealer,
+	name string,
+) (*corev1.Secret, error) {
+	if r.DisableSecrets {
+		return nil, fmt.Errorf("could not read secret %q: reading secrets is disabled", name)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: vu.Namespace, Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("could not read secret %q: %w", name, err)
+	}
+	return secret, nil
+}
+
+// secretKeyFor returns the value of the Secret key referenced by the selector and the resource version of the Secret.
+// If the selector is optional, a missing Secret or key returns no value.
+func (r *VaultUnsealerReconciler) secretKeyFor(
+	ctx context.Context,
+	vu *v1alpha1.VaultUnsealer,
+	what string,
+	ref *corev1.SecretKeySelector,
+) ([]byte, string, error) {
+	optional := ptr.Deref(ref.Optional, false)
+	secret, err := r.secretFor(ctx, vu, ref.Name)
+	if err != nil {
+		if optional && kerrors.IsNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok && !optional {
+		return nil, "", fmt.Errorf("%s secret %q has no key %q", what, ref.Name, ref.Key)
+	}
+	return value, secret.ResourceVersion, nil
+}
+
+// updateStatus updates the ready condition of the VaultUnsealer.
+// The condition of an external vault also reports whether the last check of its loop failed.
+func (r *VaultUnsealerReconciler) updateStatus(
+	ctx context.Context,
+	vu *v1alpha1.VaultUnsealer,
+	applyErr error,
+) (ctrl.Result, error) {
+	status := vu.Status.DeepCopy()
+	cond := metav1.Condition{
+		Type:               v1alpha1.ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonConfigured,
+		Message:            "configuration applied",
+		ObservedGeneration: vu.Generation,
+	}
+	if applyErr != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = reasonConfigurationError
+		cond.Message = applyErr.Error()
+	} else if vu.Spec.External != nil {
+		if st, ok := r.External.status(types.ExternalKey(vu.Namespace, vu.Name)); ok && st.Failures > 0 {
+			cond.Status = metav1.ConditionFalse
+			cond.Reason = reasonCheckFailed
+			cond.Message = "the external vault check failed: " + st.LastError
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	status.ObservedGeneration = vu.Generation
+
+	if !equality.Semantic.DeepEqual(status, &vu.Status) {
+		vu.Status = *status
+		if err := r.Status().Update(ctx, vu); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{}, applyErr
+}
+
+// unsealersForSecret maps a Secret to the VaultUnsealers referencing it.
+func (r *VaultUnsealerReconciler) unsealersForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	list := &v1alpha1.VaultUnsealerList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Error listing vault unsealers")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range list.Items {
+		if slices.Contains(list.Items[i].SecretNames(), obj.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *VaultUnsealerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.VaultUnsealer{}, builder.WithPredicates(predicate.GenerationChangedPredicate{}))
+	if !r.DisableSecrets {
+		b = b.Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.unsealersForSecret))
+	}
+	if r.Files != nil {
+		b = b.WatchesRawSource(source.Channel(r.Files.eventsChan(), &handler.EnqueueRequestForObject{}))
+	}
+	if r.External != nil {
+		b = b.WatchesRawSource(source.Channel(r.External.eventsChan(), &handler.EnqueueRequestForObject{}))
+	}
+	return b.Complete(r)
+}
@@ -0,0 +1,468 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
+	"github.com/bakito/vault-unsealer/pkg/cache"
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VaultUnsealerReconciler", func() {
+	var (
+		sut    *VaultUnsealerReconciler
+		ctx    context.Context
+		secret *corev1.Secret
+		vu     *v1alpha1.VaultUnsealer
+		req    ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "unseal", Namespace: "default"},
+			Data: map[string][]byte{
+				constants.KeyPrefixUnsealKey + "1": []byte("foo"),
+				constants.KeyPrefixUnsealKey + "2": []byte("bar"),
+			},
+		}
+		vu = &v1alpha1.VaultUnsealer{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "default"},
+			Spec: v1alpha1.VaultUnsealerSpec{
+				StatefulSet: "vault",
+				KeySource: v1alpha1.KeySource{
+					SecretRef: &corev1.LocalObjectReference{Name: secret.Name},
+				},
+			},
+		}
+		req = ctrl.Request{NamespacedName: client.ObjectKeyFromObject(vu)}
+	})
+
+	setup := func(objs ...client.Object) {
+		s := runtime.NewScheme()
+		Ω(clientgoscheme.AddToScheme(s)).ShouldNot(HaveOccurred())
+		Ω(v1alpha1.AddToScheme(s)).ShouldNot(HaveOccurred())
+		sut = &VaultUnsealerReconciler{
+			Client: fake.NewClientBuilder().
+				WithScheme(s).
+				WithObjects(objs...).
+				WithStatusSubresource(&v1alpha1.VaultUnsealer{}).
+				Build(),
+			Scheme:   s,
+			Cache:    cache.NewSimple(false),
+			External: &ExternalHandler{},
+			Files:    &KeyFileWatcher{},
+		}
+	}
+
+	readyCondition := func() *metav1.Condition {
+		current := &v1alpha1.VaultUnsealer{}
+		Ω(sut.Get(ctx, req.NamespacedName, current)).ShouldNot(HaveOccurred())
+		return meta.FindStatusCondition(current.Status.Conditions, v1alpha1.ConditionTypeReady)
+	}
+
+	It("should add the vault info of a stateful set to the cache", func() {
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.StatefulSet).Should(Equal("vault"))
+		Ω(vi.UnsealKeys).Should(ConsistOf("foo", "bar"))
+
+		cond := readyCondition()
+		Ω(cond).ShouldNot(BeNil())
+		Ω(cond.Status).Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should apply the auth settings of the spec", func() {
+		delete(secret.Data, constants.KeyPrefixUnsealKey+"1")
+		delete(secret.Data, constants.KeyPrefixUnsealKey+"2")
+		vu.Spec.KeySource.VaultPath = "secret/unseal"
+		vu.Spec.Auth = &v1alpha1.Auth{Method: v1alpha1.AuthMethodKubernetes, Role: "unsealer", MountPath: "k8s"}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.SecretPath).Should(Equal("secret/unseal"))
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodKubernetes))
+		Ω(vi.Role).Should(Equal("unsealer"))
+		Ω(vi.MountPath).Should(Equal("k8s"))
+	})
+
+	It("should read the approle credentials from the credentials secret", func() {
+		creds := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "approle", Namespace: "default"},
+			Data: map[string][]byte{
+				constants.KeyRoleID:   []byte("role-id"),
+				constants.KeySecretID: []byte("secret-id"),
+			},
+		}
+		vu.Spec.KeySource = v1alpha1.KeySource{VaultPath: "secret/unseal"}
+		vu.Spec.Auth = &v1alpha1.Auth{
+			Method:               v1alpha1.AuthMethodAppRole,
+			CredentialsSecretRef: &corev1.LocalObjectReference{Name: creds.Name},
+		}
+		setup(creds, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodAppRole))
+		Ω(vi.RoleID).Should(Equal("role-id"))
+		Ω(vi.SecretID).Should(Equal("secret-id"))
+	})
+
+	It("should apply the jwt token settings", func() {
+		vu.Spec.KeySource = v1alpha1.KeySource{VaultPath: "secret/unseal"}
+		vu.Spec.Auth = &v1alpha1.Auth{
+			Method:          v1alpha1.AuthMethodJWT,
+			Role:            "unsealer",
+			Audience:        "vault",
+			TokenExpiration: &metav1.Duration{Duration: time.Hour},
+		}
+		setup(vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodJWT))
+		Ω(vi.Audience).Should(Equal("vault"))
+		Ω(vi.TokenExpiration).Should(Equal(time.Hour))
+	})
+
+	It("should read the unseal keys from the key path and watch it", func() {
+		dir := GinkgoT().TempDir()
+		Ω(os.WriteFile(filepath.Join(dir, "unsealKey1"), []byte("foo"), 0o600)).ShouldNot(HaveOccurred())
+		vu.Spec.KeySource = v1alpha1.KeySource{Path: dir}
+		setup(vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		key := types.StatefulSetKey("default", "vault")
+		vi := sut.Cache.VaultInfoFor(key)
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(ConsistOf("foo"))
+		Ω(vi.KeyPath).Should(Equal(dir))
+		Ω(sut.Files.snapshot()).Should(HaveKeyWithValue(req.NamespacedName, dir))
+
+		Ω(sut.Delete(ctx, vu)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sut.Files.snapshot()).ShouldNot(HaveKey(req.NamespacedName))
+	})
+
+	It("should decrypt the unseal keys of the key path", func() {
+		dir := GinkgoT().TempDir()
+		Ω(os.WriteFile(filepath.Join(dir, "unsealKey1"), []byte("enc:foo"), 0o600)).ShouldNot(HaveOccurred())
+		vu.Spec.KeySource = v1alpha1.KeySource{Path: dir}
+		setup(vu)
+		sut.Decrypter = prefixDecrypter("enc:")
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(ConsistOf("foo"))
+	})
+
+	It("should not read secrets if disabled", func() {
+		setup(secret, vu)
+		sut.DisableSecrets = true
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(HaveOccurred())
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should report a missing secret in the status", func() {
+		setup(vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(HaveOccurred())
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+
+		cond := readyCondition()
+		Ω(cond).ShouldNot(BeNil())
+		Ω(cond.Status).Should(Equal(metav1.ConditionFalse))
+		Ω(cond.Reason).Should(Equal(reasonConfigurationError))
+	})
+
+	It("should register an external check loop", func() {
+		vu.Spec.StatefulSet = ""
+		vu.Spec.External = &v1alpha1.External{
+			Source:  "https://vault.bakito.org:8200",
+			Targets: []string{"https://vault-1.bakito.org:8200", "https://vault-2.bakito.org:8200"},
+		}
+		vu.Spec.Interval = &metav1.Duration{Duration: constants.DefaultExternalInterval / 2}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(sut.Cache.VaultInfoFor(types.ExternalKey("default", vu.Name))).ShouldNot(BeNil())
+		Ω(sut.External.configs).Should(HaveKey(types.ExternalKey("default", vu.Name)))
+		cfg := sut.External.configs[types.ExternalKey("default", vu.Name)]
+		Ω(cfg.interval).Should(Equal(constants.DefaultExternalInterval / 2))
+		Ω(cfg.targets).Should(HaveLen(2))
+	})
+
+	It("should report a failing external check in the ready condition", func() {
+		vu.Spec.StatefulSet = ""
+		vu.Spec.External = &v1alpha1.External{
+			Source:  "https://vault.bakito.org:8200",
+			Targets: []string{"https://vault-1.bakito.org:8200"},
+		}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		key := types.ExternalKey("default", vu.Name)
+		Ω(sut.External.configs[key].owner).Should(Equal(req.NamespacedName))
+
+		loop := &externalLoop{version: sut.External.configs[key].version}
+		sut.External.loops = map[types.VaultKey]*externalLoop{key: loop}
+		loop.setStatus(errors.New("connection refused"))
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		cond := readyCondition()
+		Ω(cond.Status).Should(Equal(metav1.ConditionFalse))
+		Ω(cond.Reason).Should(Equal(reasonCheckFailed))
+		Ω(cond.Message).Should(Equal("the external vault check failed: connection refused"))
+
+		loop.setStatus(nil)
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(readyCondition().Status).Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should use the client certificate of the credentials secret for the source vault", func() {
+		cert, key := newClientCertificate()
+		creds := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cert", Namespace: "default"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: cert, corev1.TLSPrivateKeyKey: key},
+		}
+		vu.Spec.StatefulSet = ""
+		vu.Spec.External = &v1alpha1.External{
+			Source:  "https://vault.bakito.org:8200",
+			Targets: []string{"https://vault-1.bakito.org:8200"},
+		}
+		vu.Spec.KeySource = v1alpha1.KeySource{VaultPath: "secret/unseal"}
+		vu.Spec.Auth = &v1alpha1.Auth{
+			Method:               v1alpha1.AuthMethodCert,
+			Role:                 "unsealer",
+			CredentialsSecretRef: &corev1.LocalObjectReference{Name: creds.Name},
+		}
+		setup(creds, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.ExternalKey("default", vu.Name))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.LoginMethod()).Should(Equal(constants.AuthMethodCert))
+		Ω(vi.ClientCert).Should(Equal(string(cert)))
+		Ω(vi.ClientKey).Should(Equal(string(key)))
+		version := sut.External.configs[types.ExternalKey("default", vu.Name)].version
+
+		// a new certificate restarts the check loop
+		creds.Data[corev1.TLSCertKey], creds.Data[corev1.TLSPrivateKeyKey] = newClientCertificate()
+		Ω(sut.Update(ctx, creds)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sut.External.configs[types.ExternalKey("default", vu.Name)].version).ShouldNot(Equal(version))
+	})
+
+	It("should remove the cache entry when the vault unsealer is deleted", func() {
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).ShouldNot(BeNil())
+
+		Ω(sut.Delete(ctx, vu)).ShouldNot(HaveOccurred())
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).Should(BeNil())
+	})
+
+	It("should map a secret to the referencing vault unsealers", func() {
+		setup(secret, vu)
+		Ω(sut.unsealersForSecret(ctx, secret)).Should(ConsistOf(req))
+	})
+
+	It("should apply the init settings if the key source secret does not exist yet", func() {
+		vu.Spec.Init = &v1alpha1.Init{RootToken: v1alpha1.RootTokenStore}
+		setup(vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(BeEmpty())
+		Ω(vi.Init).Should(Equal(&types.InitConfig{
+			Shares:         constants.DefaultInitShares,
+			Threshold:      constants.DefaultInitThreshold,
+			SecretName:     secret.Name,
+			StoreRootToken: true,
+		}))
+	})
+
+	It("should read the unseal keys of the init secret", func() {
+		initSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "init", Namespace: "default"},
+			Data:       map[string][]byte{constants.KeyInitJSON: []byte(`{"unseal_keys_b64": ["baz"]}`)},
+		}
+		vu.Spec.Init = &v1alpha1.Init{SecretName: initSecret.Name}
+		setup(secret, initSecret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.UnsealKeys).Should(Equal([]string{"foo", "bar", "baz"}))
+		Ω(vi.Init.SecretName).Should(Equal(initSecret.Name))
+		Ω(sut.unsealersForSecret(ctx, initSecret)).Should(ConsistOf(req))
+	})
+
+	It("should apply the raft join settings with the leader ca", func() {
+		ca := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-tls", Namespace: "default"},
+			Data:       map[string][]byte{"ca.crt": []byte("ca")},
+		}
+		vu.Spec.RaftJoin = &v1alpha1.RaftJoin{
+			LeaderCASecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ca.Name},
+				Key:                  "ca.crt",
+			},
+			LeaderTLSServerName: "vault.vault-internal",
+		}
+		setup(secret, ca, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.RaftJoin).Should(Equal(&types.RaftJoinConfig{LeaderCACert: "ca", LeaderTLSServerName: "vault.vault-internal"}))
+		Ω(sut.unsealersForSecret(ctx, ca)).Should(ConsistOf(req))
+	})
+
+	It("should ignore a missing optional ca secret", func() {
+		vu.Spec.RaftJoin = &v1alpha1.RaftJoin{
+			LeaderCASecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "vault-tls"},
+				Key:                  "ca.crt",
+				Optional:             ptr.To(true),
+			},
+		}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.RaftJoin.LeaderCACert).Should(BeEmpty())
+	})
+
+	It("should fail for a missing ca secret that is not optional", func() {
+		vu.Spec.StatefulSet = ""
+		vu.Spec.External = &v1alpha1.External{
+			Source:  "https://vault.bakito.org:8200",
+			Targets: []string{"https://vault-1.bakito.org:8200"},
+		}
+		vu.Spec.TLS = &v1alpha1.TLS{CASecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "vault-tls"},
+			Key:                  "ca.crt",
+		}}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring(`could not read secret "vault-tls"`)))
+
+		vu.Spec.TLS.CASecretRef.Optional = ptr.To(true)
+		setup(secret, vu)
+		_, err = sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sut.External.configs).Should(HaveKey(types.ExternalKey("default", vu.Name)))
+	})
+
+	It("should apply the unseal order with default delays", func() {
+		vu.Spec.UnsealOrder = &v1alpha1.UnsealOrder{
+			Strategy:    v1alpha1.UnsealOrderLeaderFirst,
+			SettleDelay: &metav1.Duration{Duration: time.Second},
+		}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.Ordered()).Should(BeTrue())
+		Ω(vi.UnsealOrder).Should(Equal(constants.UnsealOrderLeaderFirst))
+		Ω(vi.SettleDelay).Should(Equal(time.Second))
+		Ω(vi.ActiveTimeout).Should(Equal(constants.DefaultActiveTimeout))
+	})
+
+	It("should apply the seal check interval of the stateful set", func() {
+		vu.Spec.Interval = &metav1.Duration{Duration: 5 * time.Minute}
+		setup(secret, vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		vi := sut.Cache.VaultInfoFor(types.StatefulSetKey("default", "vault"))
+		Ω(vi).ShouldNot(BeNil())
+		Ω(vi.SealCheckInterval).Should(Equal(5 * time.Minute))
+	})
+
+	It("should reject an init config without a target for the init output", func() {
+		vu.Spec.KeySource = v1alpha1.KeySource{VaultPath: "secret/unseal"}
+		vu.Spec.Init = &v1alpha1.Init{}
+		setup(vu)
+		_, err := sut.Reconcile(ctx, req)
+		Ω(err).Should(MatchError(ContainSubstring("init requires a secret or a vault to store the init output in")))
+		Ω(readyCondition().Status).Should(Equal(metav1.ConditionFalse))
+	})
+})
+
+// newClientCertificate returns a PEM encoded self-signed client certificate and key.
+func newClientCertificate() (cert, key []byte) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).ShouldNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vault-unsealer"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+	Ω(err).ShouldNot(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(pk)
+	Ω(err).ShouldNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
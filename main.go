@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -15,17 +19,19 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	crtlcache "sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/bakito/vault-unsealer/api/v1alpha1"
 	"github.com/bakito/vault-unsealer/controllers"
 	"github.com/bakito/vault-unsealer/pkg/cache"
 	"github.com/bakito/vault-unsealer/pkg/constants"
 	"github.com/bakito/vault-unsealer/pkg/hierarchy"
+	"github.com/bakito/vault-unsealer/pkg/keysource"
 	"github.com/bakito/vault-unsealer/pkg/logging"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
@@ -36,10 +42,21 @@ var (
 
 	addrEnvVarName     string
 	vaultContainerName string
+	migrateSecrets     bool
+	watchNamespaces    string
+	renewVaultTokens   bool
+	disableSecrets     bool
+	livenessTimeout    time.Duration
+	sealCheckInterval  time.Duration
+
+	decryptionKeyFile        string
+	decryptionPassphraseFile string
+	decrypter                keysource.Decrypter
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
@@ -51,6 +68,29 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&enableSharedCache, "shared-cache", false, "Enable shared cache between the operator instances.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		fmt.Sprintf(
+			"Comma separated list of namespaces to watch for vaults and unseal configurations, '%s' to watch all namespaces. "+
+				"Defaults to the namespace of the unsealer.",
+			constants.AllNamespaces,
+		))
+	flag.BoolVar(&migrateSecrets, "migrate-secrets", false,
+		"Create a VaultUnsealer resource for each labeled unseal secret that is not yet referenced by one.")
+	flag.BoolVar(&renewVaultTokens, "renew-vault-tokens", false,
+		"Keep the vault token used to read the unseal keys and renew it, instead of revoking it after the keys are read.")
+	flag.BoolVar(&disableSecrets, "disable-secrets", false,
+		"Do not read or watch Secrets, e.g. if the unseal keys are mounted as files. "+
+			"Allows running the unsealer without access to Secrets.")
+	flag.DurationVar(&livenessTimeout, "liveness-timeout", constants.DefaultLivenessTimeout,
+		"Duration after which a reconcile or external vault check without progress fails the liveness check.")
+	flag.DurationVar(&sealCheckInterval, "seal-check-interval", constants.DefaultSealCheckInterval,
+		"Interval of the seal check of the pods of StatefulSets that do not define an interval, e.g. those configured by "+
+			"labeled Secrets.")
+	flag.StringVar(&decryptionKeyFile, "decryption-key-file", "",
+		"Path of a PGP private key or age identity file. If set, the unseal keys of Secrets and key files "+
+			"are expected to be encrypted with it and are decrypted in memory.")
+	flag.StringVar(&decryptionPassphraseFile, "decryption-passphrase-file", "",
+		"Path of a file containing the passphrase of the PGP private key.")
 	flag.StringVar(
 		&vaultContainerName,
 		"container-name",
@@ -77,6 +117,17 @@ func main() {
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 	logging.SetupLogger(true)
+	if migrateSecrets && disableSecrets {
+		setupLog.Error(errors.New("invalid flags"), "-migrate-secrets can not be used with -disable-secrets")
+		os.Exit(1)
+	}
+	if decryptionKeyFile != "" {
+		var err error
+		if decrypter, err = loadDecrypter(decryptionKeyFile, decryptionPassphraseFile); err != nil {
+			setupLog.Error(err, "unable to load the decryption key")
+			os.Exit(1)
+		}
+	}
 	podNamespace := os.Getenv(constants.EnvNamespace)
 
 	cfg := ctrl.GetConfigOrDie()
@@ -96,12 +147,13 @@ func main() {
 
 	past132 := minor >= 33
 
+	namespaces := parseNamespaces(podNamespace, watchNamespaces)
+	setupLog.WithValues("namespaces", namespaces).Info("watching namespaces")
+
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme: scheme,
 		Cache: crtlcache.Options{
-			DefaultNamespaces: map[string]crtlcache.Config{
-				podNamespace: {},
-			},
+			DefaultNamespaces: defaultNamespaces(namespaces),
 		},
 		Metrics: server.Options{
 			BindAddress: ":8080",
@@ -135,33 +187,63 @@ func main() {
 	} else {
 		c = cache.NewSimple(past132)
 	}
-	run(ctx, mgr, podNamespace, c)
+	if err := metrics.RegisterCachedUnsealKeys(func() map[string]int { return cache.KeyCounts(c) }); err != nil {
+		setupLog.Error(err, "unable to register metrics")
+		os.Exit(1)
+	}
+	run(ctx, mgr, namespaces, c)
 }
 
-func run(ctx context.Context, mgr manager.Manager, podNamespace string, c cache.Cache) {
-	secretsStatefulSet := &corev1.SecretList{}
-	if err := mgr.GetAPIReader().List(
-		ctx,
-		secretsStatefulSet,
-		client.HasLabels{constants.LabelStatefulSetName},
-		client.InNamespace(podNamespace),
-	); err != nil {
-		setupLog.Error(err, "unable to find secrets statefulset")
-		os.Exit(1)
+// parseNamespaces returns the namespaces to watch. An empty list is returned if all namespaces should be watched.
+// The namespace of the unsealer is always watched, as it is required for the shared cache.
+func parseNamespaces(podNamespace, watch string) []string {
+	namespaces := []string{podNamespace}
+	for ns := range strings.SplitSeq(watch, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == constants.AllNamespaces {
+			return nil
+		}
+		if ns != "" && !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
 	}
-	setupLog.WithValues("secrets", len(secretsStatefulSet.Items)).Info("found unseal secrets statefulset")
+	return namespaces
+}
 
-	secretsExternal := &corev1.SecretList{}
-	if err := mgr.GetAPIReader().List(
-		ctx,
-		secretsExternal,
-		client.HasLabels{constants.LabelExternal},
-		client.InNamespace(podNamespace),
-	); err != nil {
-		setupLog.Error(err, "unable to find secrets external")
-		os.Exit(1)
+// loadDecrypter reads the private key and the optional passphrase used to decrypt the unseal keys.
+func loadDecrypter(keyFile, passphraseFile string) (keysource.Decrypter, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var passphrase []byte
+	if passphraseFile != "" {
+		p, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = []byte(strings.TrimSpace(string(p)))
+	}
+	return keysource.NewDecrypter(key, passphrase)
+}
+
+// defaultNamespaces returns the namespace configuration of the manager cache.
+func defaultNamespaces(namespaces []string) map[string]crtlcache.Config {
+	if len(namespaces) == 0 {
+		// watch all namespaces
+		return nil
+	}
+	m := make(map[string]crtlcache.Config)
+	for _, ns := range namespaces {
+		m[ns] = crtlcache.Config{}
+	}
+	return m
+}
+
+func run(ctx context.Context, mgr manager.Manager, namespaces []string, c cache.Cache) {
+	if migrateSecrets {
+		migrate(ctx, mgr, namespaces)
 	}
-	setupLog.WithValues("secrets", len(secretsExternal.Items)).Info("found unseal secrets external")
 
 	sel, err := hierarchy.GetDeploymentSelector(ctx, mgr.GetAPIReader())
 	if err != nil {
@@ -178,32 +260,110 @@ func run(ctx context.Context, mgr manager.Manager, podNamespace string, c cache.
 		setupLog.Error(err, "unable to create controller", "controller", "Endpoint")
 		os.Exit(1)
 	}
-	if err := (&controllers.PodReconciler{
+	tokens := &controllers.ServiceAccountTokens{
+		Client:         mgr.GetClient(),
+		Namespace:      os.Getenv(constants.EnvNamespace),
+		ServiceAccount: os.Getenv(constants.EnvServiceAccountName),
+	}
+
+	vaultTokens := &controllers.VaultTokens{Renew: renewVaultTokens}
+	if err := vaultTokens.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up vault token renewal")
+		os.Exit(1)
+	}
+
+	health := &controllers.Health{
+		Reader:          mgr.GetClient(),
+		Cache:           c,
+		DisableSecrets:  disableSecrets,
+		LivenessTimeout: livenessTimeout,
+	}
+
+	pods := &controllers.PodReconciler{
 		Client:             mgr.GetClient(),
 		Scheme:             mgr.GetScheme(),
 		Cache:              c,
 		VaultContainerName: vaultContainerName,
 		AddrEnvVarName:     addrEnvVarName,
-	}).SetupWithManager(mgr, secretsStatefulSet.Items); err != nil {
+		Tokens:             tokens,
+		VaultTokens:        vaultTokens,
+		Recorder:           mgr.GetEventRecorder("vault-unsealer"),
+		Decrypter:          decrypter,
+		Health:             health,
+	}
+	if err := pods.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
-	// +kubebuilder:scaffold:builder
 
-	if err := (&controllers.ExternalHandler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Cache:  c,
-	}).SetupWithManager(mgr, secretsExternal.Items); err != nil {
+	if err := (&controllers.SealPoller{Pods: pods, Cache: c, Interval: sealCheckInterval}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up seal poller")
+		os.Exit(1)
+	}
+
+	external := &controllers.ExternalHandler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Cache:       c,
+		Tokens:      tokens,
+		VaultTokens: vaultTokens,
+		Decrypter:   decrypter,
+		Recorder:    mgr.GetEventRecorder("vault-unsealer"),
+		Health:      health,
+	}
+	health.External = external
+	if err := external.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "External")
 		os.Exit(1)
 	}
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+	files := &controllers.KeyFileWatcher{}
+	if err := files.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up key file watcher")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.VaultUnsealerReconciler{
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		Cache:          c,
+		External:       external,
+		Pods:           pods,
+		Files:          files,
+		DisableSecrets: disableSecrets,
+		Decrypter:      decrypter,
+		Health:         health,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VaultUnsealer")
+		os.Exit(1)
+	}
+
+	if !disableSecrets {
+		if err := (&controllers.SecretReconciler{
+			Client:    mgr.GetClient(),
+			Scheme:    mgr.GetScheme(),
+			Cache:     c,
+			External:  external,
+			Pods:      pods,
+			Decrypter: decrypter,
+			Recorder:  mgr.GetEventRecorder("vault-unsealer"),
+			Health:    health,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Secret")
+			os.Exit(1)
+		}
+	}
+	// +kubebuilder:scaffold:builder
+
+	if err := health.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up health checks")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", health.Livez); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddReadyzCheck("readyz", health.Readyz); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
@@ -213,3 +373,55 @@ func run(ctx context.Context, mgr manager.Manager, podNamespace string, c cache.
 		os.Exit(1)
 	}
 }
+
+// migrate creates a VaultUnsealer resource for each labeled unseal secret that is not yet referenced by one.
+func migrate(ctx context.Context, mgr manager.Manager, namespaces []string) {
+	if len(namespaces) == 0 {
+		// list in all namespaces
+		namespaces = []string{""}
+	}
+
+	var migrated []v1alpha1.VaultUnsealer
+	for _, ns := range namespaces {
+		secretsStatefulSet := &corev1.SecretList{}
+		if err := mgr.GetAPIReader().List(
+			ctx,
+			secretsStatefulSet,
+			client.HasLabels{constants.LabelStatefulSetName},
+			client.InNamespace(ns),
+		); err != nil {
+			setupLog.Error(err, "unable to find secrets statefulset")
+			os.Exit(1)
+		}
+
+		secretsExternal := &corev1.SecretList{}
+		if err := mgr.GetAPIReader().List(
+			ctx,
+			secretsExternal,
+			client.HasLabels{constants.LabelExternal},
+			client.InNamespace(ns),
+		); err != nil {
+			setupLog.Error(err, "unable to find secrets external")
+			os.Exit(1)
+		}
+
+		unsealers := &v1alpha1.VaultUnsealerList{}
+		if err := mgr.GetAPIReader().List(ctx, unsealers, client.InNamespace(ns)); err != nil {
+			setupLog.Error(err, "unable to list vault unsealers")
+			os.Exit(1)
+		}
+
+		m, err := controllers.MigrateSecrets(
+			ctx,
+			mgr.GetClient(),
+			slices.Concat(secretsStatefulSet.Items, secretsExternal.Items),
+			unsealers.Items,
+		)
+		if err != nil {
+			setupLog.Error(err, "unable to migrate secrets")
+			os.Exit(1)
+		}
+		migrated = append(migrated, m...)
+	}
+	setupLog.WithValues("unsealers", len(migrated)).Info("migrated unseal secrets")
+}
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"maps"
+	"sync"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -11,18 +13,22 @@ import (
 
 // Cache defines the interface for managing Vault information cache.
 type Cache interface {
-	// Vaults returns the list of stateful sets for which Vault information is cached.
-	Vaults() []string
+	// Vaults returns the keys of the vaults for which Vault information is cached.
+	Vaults() []types.VaultKey
 	// VaultInfoFor retrieves the Vault information for the specified instance.
-	VaultInfoFor(name string) *types.VaultInfo
+	VaultInfoFor(key types.VaultKey) *types.VaultInfo
 	// SetVaultInfoFor sets the Vault information for the specified instance.
-	SetVaultInfoFor(name string, info *types.VaultInfo)
+	SetVaultInfoFor(key types.VaultKey, info *types.VaultInfo)
+	// DeleteVaultInfoFor removes the Vault information for the specified instance.
+	DeleteVaultInfoFor(key types.VaultKey)
 	// Sync synchronizes the cache with the external source, if applicable.
 	Sync()
 	// SetMember sets the member status for the cache, if applicable.
 	SetMember(members map[string]string) bool
 	// IsK8sPast123 return whether kubernetes version is past 1.32 or not
 	IsK8sPast123() bool
+	// Ready returns an error if the cache is not ready to be used.
+	Ready() error
 }
 
 // RunnableCache extends the Cache interface with additional methods for running as a controller-runtime Runnable.
@@ -35,11 +41,12 @@ type RunnableCache interface {
 
 // NewSimple creates a new simple cache instance.
 func NewSimple(past132 bool) Cache {
-	return &simpleCache{vaults: make(map[string]*types.VaultInfo), past132: past132}
+	return &simpleCache{vaults: make(map[types.VaultKey]*types.VaultInfo), past132: past132}
 }
 
 type simpleCache struct {
-	vaults  map[string]*types.VaultInfo
+	mux     sync.RWMutex
+	vaults  map[types.VaultKey]*types.VaultInfo
 	past132 bool
 }
 
@@ -53,14 +60,21 @@ func (*simpleCache) SetMember(_ map[string]string) bool {
 	return false
 }
 
+// Ready always returns nil for simple cache.
+func (*simpleCache) Ready() error {
+	return nil
+}
+
 // Sync is a no-op for simple cache.
 func (*simpleCache) Sync() {
 	// No-op for simple cache
 }
 
 // Vaults returns the list of instances for which Vault information is cached.
-func (s *simpleCache) Vaults() []string {
-	var out []string
+func (s *simpleCache) Vaults() []types.VaultKey {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	var out []types.VaultKey
 	for k := range s.vaults {
 		out = append(out, k)
 	}
@@ -68,13 +82,41 @@ func (s *simpleCache) Vaults() []string {
 }
 
 // VaultInfoFor retrieves the Vault information for the specified instance.
-func (s *simpleCache) VaultInfoFor(name string) *types.VaultInfo {
-	return s.vaults[name]
+func (s *simpleCache) VaultInfoFor(key types.VaultKey) *types.VaultInfo {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.vaults[key]
 }
 
 // SetVaultInfoFor sets the Vault information for the specified instance.
-func (s *simpleCache) SetVaultInfoFor(name string, info *types.VaultInfo) {
-	s.vaults[name] = info
+func (s *simpleCache) SetVaultInfoFor(key types.VaultKey, info *types.VaultInfo) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.vaults[key] = info
+}
+
+// DeleteVaultInfoFor removes the Vault information for the specified instance.
+func (s *simpleCache) DeleteVaultInfoFor(key types.VaultKey) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.vaults, key)
+}
+
+// snapshot returns a copy of all cached Vault information.
+func (s *simpleCache) snapshot() map[types.VaultKey]*types.VaultInfo {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return maps.Clone(s.vaults)
+}
+
+// replace replaces all cached Vault information.
+func (s *simpleCache) replace(vaults map[types.VaultKey]*types.VaultInfo) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if vaults == nil {
+		vaults = make(map[types.VaultKey]*types.VaultInfo)
+	}
+	s.vaults = vaults
 }
 
 // StartCache starts the cache, but it's a no-op for simple cache.
@@ -82,3 +124,14 @@ func (*simpleCache) StartCache(_ context.Context) error {
 	// No-op for simple cache
 	return nil
 }
+
+// KeyCounts returns the number of cached unseal keys per vault.
+func KeyCounts(c Cache) map[string]int {
+	counts := make(map[string]int)
+	for _, key := range c.Vaults() {
+		if vi := c.VaultInfoFor(key); vi != nil {
+			counts[key.String()] = len(vi.UnsealKeys)
+		}
+	}
+	return counts
+}
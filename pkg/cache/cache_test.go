@@ -9,7 +9,11 @@ import (
 )
 
 var _ = Describe("SimpleCache", func() {
-	var simpleCache cache.Cache
+	var (
+		simpleCache  cache.Cache
+		statefulSet1 = types.StatefulSetKey("default", "statefulSet1")
+		statefulSet2 = types.StatefulSetKey("default", "statefulSet2")
+	)
 
 	BeforeEach(func() {
 		simpleCache = cache.NewSimple(false)
@@ -24,14 +28,14 @@ var _ = Describe("SimpleCache", func() {
 
 		Context("when there are vaults", func() {
 			BeforeEach(func() {
-				simpleCache.SetVaultInfoFor("statefulSet1", &types.VaultInfo{})
-				simpleCache.SetVaultInfoFor("statefulSet2", &types.VaultInfo{})
+				simpleCache.SetVaultInfoFor(statefulSet1, &types.VaultInfo{})
+				simpleCache.SetVaultInfoFor(statefulSet2, &types.VaultInfo{})
 			})
 
 			It("should return a list of stateful sets", func() {
 				vaults := simpleCache.Vaults()
-				Expect(vaults).To(ContainElement("statefulSet1"))
-				Expect(vaults).To(ContainElement("statefulSet2"))
+				Expect(vaults).To(ContainElement(statefulSet1))
+				Expect(vaults).To(ContainElement(statefulSet2))
 				Expect(len(vaults)).To(Equal(2))
 			})
 		})
@@ -41,18 +45,18 @@ var _ = Describe("SimpleCache", func() {
 		Context("when the stateful set has vault information", func() {
 			BeforeEach(func() {
 				vaultInfo := &types.VaultInfo{}
-				simpleCache.SetVaultInfoFor("statefulSet1", vaultInfo)
+				simpleCache.SetVaultInfoFor(statefulSet1, vaultInfo)
 			})
 
 			It("should return the vault information", func() {
-				info := simpleCache.VaultInfoFor("statefulSet1")
+				info := simpleCache.VaultInfoFor(statefulSet1)
 				Expect(info).NotTo(BeNil())
 			})
 		})
 
 		Context("when the stateful set does not have vault information", func() {
 			It("should return nil", func() {
-				info := simpleCache.VaultInfoFor("statefulSet1")
+				info := simpleCache.VaultInfoFor(statefulSet1)
 				Expect(info).To(BeNil())
 			})
 		})
@@ -61,12 +65,34 @@ var _ = Describe("SimpleCache", func() {
 	Describe("SetVaultInfoFor", func() {
 		It("should set the vault information for the specified stateful set", func() {
 			vaultInfo := &types.VaultInfo{}
-			simpleCache.SetVaultInfoFor("statefulSet1", vaultInfo)
-			info := simpleCache.VaultInfoFor("statefulSet1")
+			simpleCache.SetVaultInfoFor(statefulSet1, vaultInfo)
+			info := simpleCache.VaultInfoFor(statefulSet1)
 			Expect(info).To(Equal(vaultInfo))
 		})
 	})
 
+	Describe("DeleteVaultInfoFor", func() {
+		It("should remove the vault information for the specified stateful set", func() {
+			simpleCache.SetVaultInfoFor(statefulSet1, &types.VaultInfo{})
+			simpleCache.SetVaultInfoFor(statefulSet2, &types.VaultInfo{})
+			simpleCache.DeleteVaultInfoFor(statefulSet1)
+			Expect(simpleCache.VaultInfoFor(statefulSet1)).To(BeNil())
+			Expect(simpleCache.Vaults()).To(ConsistOf(statefulSet2))
+		})
+	})
+
+	Describe("Keys", func() {
+		It("should not mix up vaults of different kinds with the same name", func() {
+			sts := &types.VaultInfo{StatefulSet: "vault"}
+			ext := &types.VaultInfo{}
+			simpleCache.SetVaultInfoFor(types.StatefulSetKey("default", "vault"), sts)
+			simpleCache.SetVaultInfoFor(types.ExternalKey("default", "vault"), ext)
+			Expect(simpleCache.VaultInfoFor(types.StatefulSetKey("default", "vault"))).To(BeIdenticalTo(sts))
+			Expect(simpleCache.VaultInfoFor(types.ExternalKey("default", "vault"))).To(BeIdenticalTo(ext))
+			Expect(simpleCache.VaultInfoFor(types.StatefulSetKey("other", "vault"))).To(BeNil())
+		})
+	})
+
 	Describe("SetMember", func() {
 		It("should be a no-op and return false", func() {
 			Expect(simpleCache.SetMember(nil)).To(BeFalse())
@@ -79,4 +105,27 @@ var _ = Describe("SimpleCache", func() {
 			// No assertions as it's a no-op
 		})
 	})
+
+	Describe("Ready", func() {
+		It("should always be ready", func() {
+			Expect(simpleCache.Ready()).To(Succeed())
+		})
+
+		It("should not be ready before the k8s cache server is listening", func() {
+			c, err := cache.NewK8s(nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Ready()).To(MatchError("cache server is not listening"))
+		})
+	})
+
+	Describe("KeyCounts", func() {
+		It("should count the unseal keys per vault", func() {
+			simpleCache.SetVaultInfoFor(statefulSet1, &types.VaultInfo{UnsealKeys: []string{"a", "b"}})
+			simpleCache.SetVaultInfoFor(statefulSet2, &types.VaultInfo{})
+			Expect(cache.KeyCounts(simpleCache)).To(Equal(map[string]int{
+				statefulSet1.String(): 2,
+				statefulSet2.String(): 0,
+			}))
+		})
+	})
 })
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,12 +22,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
 // apiPort is the port for the cache API server.
 const apiPort = 8866
 
+// askPeersRetryDelay is the delay before the peers are asked again for their cache information after a failure.
+var askPeersRetryDelay = 10 * time.Second
+
 var (
 	log  = ctrl.Log.WithName("cache") // Logger for the cache package.
 	once sync.Once                    // Ensures that certain operations are performed only once.
@@ -45,6 +50,11 @@ type k8sCache struct {
 	peerToken      string        // Token for peer communication.
 	client         *resty.Client // HTTP client for communication with peers.
 	past132        bool
+
+	electedCh    <-chan struct{} // electedCh is closed once the instance is elected leader.
+	listening    atomic.Bool     // listening is true while the cache API server accepts connections.
+	elected      atomic.Bool     // elected is true once the instance is elected leader.
+	bootstrapped atomic.Bool     // bootstrapped is true once the cache information is received from the peers.
 }
 
 func (c *k8sCache) IsK8sPast123() bool {
@@ -54,7 +64,7 @@ func (c *k8sCache) IsK8sPast123() bool {
 // NewK8s creates a new Kubernetes cache instance.
 func NewK8s(reader client.Reader, past132 bool) (RunnableCache, error) {
 	c := &k8sCache{
-		simpleCache:    simpleCache{vaults: make(map[string]*types.VaultInfo)},
+		simpleCache:    simpleCache{vaults: make(map[types.VaultKey]*types.VaultInfo)},
 		reader:         reader,
 		clusterMembers: map[string]string{},
 		past132:        past132,
@@ -65,18 +75,46 @@ func NewK8s(reader client.Reader, past132 bool) (RunnableCache, error) {
 
 // SetupWithManager sets up the Kubernetes cache with the provided manager.
 func (c *k8sCache) SetupWithManager(mgr ctrl.Manager) error {
-	go func() {
-		// Block until our controller manager is elected leader.
-		<-mgr.Elected()
+	c.electedCh = mgr.Elected()
+	return mgr.Add(c)
+}
 
-		// Ask peers if we do not have vaults yet.
-		if len(c.vaults) == 0 || c.token == "" {
-			if err := c.AskPeers(context.Background()); err != nil {
-				log.Error(err, "error asking peers")
-			}
+// bootstrap blocks until our controller manager is elected leader and asks the peers for their cache information
+// until it is received, if we do not have vaults yet. Without other instances, e.g. with a single replica, the cache is
+// bootstrapped right away.
+func (c *k8sCache) bootstrap(ctx context.Context) {
+	select {
+	case <-c.electedCh:
+	case <-ctx.Done():
+		return
+	}
+	c.elected.Store(true)
+
+	for len(c.Vaults()) == 0 || c.authToken() == "" {
+		err := c.AskPeers(ctx)
+		if err == nil {
+			break
 		}
-	}()
-	return mgr.Add(c)
+		log.WithValues("retry-in", askPeersRetryDelay.String()).Error(err, "error asking peers")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(askPeersRetryDelay):
+		}
+	}
+	c.bootstrapped.Store(true)
+}
+
+// Ready returns an error if the cache API server is not listening or, once elected leader,
+// the cache information was not yet received from the peers.
+func (c *k8sCache) Ready() error {
+	if !c.listening.Load() {
+		return errors.New("cache server is not listening")
+	}
+	if c.elected.Load() && !c.bootstrapped.Load() {
+		return errors.New("cache is not bootstrapped from its peers")
+	}
+	return nil
 }
 
 // NeedLeaderElection indicates whether leader election is needed for the cache.
@@ -89,7 +127,9 @@ func (c *k8sCache) Start(ctx context.Context) error {
 	log.Info("starting shared cache")
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	r.POST("/sync/:statefulSet", c.webPostSync)
+	// the key has the format kind/namespace/name, older peers only send the stateful set name
+	r.POST("/sync/*key", c.webPostSync)
+	r.DELETE("/sync/*key", c.webDeleteSync)
 	r.GET("/info", c.webGetInfo)
 	r.PUT("/info", c.webPutInfo)
 
@@ -112,7 +152,15 @@ func (c *k8sCache) Start(ctx context.Context) error {
 	}()
 
 	log.Info("starting cache server")
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	c.listening.Store(true)
+	defer c.listening.Store(false)
+	// the peers send their cache information to our server, so they are only asked once it is listening
+	go c.bootstrap(ctx)
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
@@ -120,33 +168,64 @@ func (c *k8sCache) Start(ctx context.Context) error {
 	return nil
 }
 
-// SetVaultInfoFor sets the Vault information for the specified stateful set.
+// SetVaultInfoFor sets the Vault information for the specified vault.
 // If the Vault instance should share its information with peers, it sends the information to all peers.
-func (c *k8sCache) SetVaultInfoFor(statefulSet string, info *types.VaultInfo) {
-	c.simpleCache.SetVaultInfoFor(statefulSet, info)
+func (c *k8sCache) SetVaultInfoFor(key types.VaultKey, info *types.VaultInfo) {
+	c.simpleCache.SetVaultInfoFor(key, info)
 	if info.ShouldShare() {
 		for ip, name := range c.clusterMembers {
-			once.Do(func() {
-				if c.token == "" {
-					c.token = uuid.NewString()
-				}
-				c.client = resty.New().SetAuthToken(c.token)
-				c.client.SetTimeout(time.Second)
-			})
-			if constants.IsDevMode() {
-				ip = "localhost"
-			}
-			resp, err := c.client.R().
+			resp, err := c.peerClient().R().
 				SetBody(info).
-				Post(fmt.Sprintf("http://%s/sync/%s", net.JoinHostPort(ip, strconv.Itoa(apiPort)), statefulSet)) //nolint:revive
+				Post(syncURL(ip, key))
 			if err != nil {
-				log.WithValues("pod", name, "stateful-set", statefulSet).Error(err, "could not send owner info")
+				log.WithValues("pod", name, "vault", key.String()).Error(err, "could not send owner info")
 			} else if resp.StatusCode() != http.StatusOK {
-				log.WithValues("pod", name, "stateful-set", statefulSet, "status", resp.StatusCode()).
-					Error(errors.New("could not send owner info"), "could not send owner info")
+				err = errors.New("could not send owner info")
+				log.WithValues("pod", name, "vault", key.String(), "status", resp.StatusCode()).
+					Error(err, "could not send owner info")
 			}
+			metrics.PeerSyncs.WithLabelValues(metrics.PeerSyncSet, metrics.Result(err)).Inc()
+		}
+	}
+}
+
+// DeleteVaultInfoFor removes the Vault information for the specified vault and from all peers.
+func (c *k8sCache) DeleteVaultInfoFor(key types.VaultKey) {
+	c.simpleCache.DeleteVaultInfoFor(key)
+	for ip, name := range c.clusterMembers {
+		resp, err := c.peerClient().R().Delete(syncURL(ip, key))
+		if err != nil {
+			log.WithValues("pod", name, "vault", key.String()).Error(err, "could not delete owner info")
+		} else if resp.StatusCode() != http.StatusOK {
+			err = errors.New("could not delete owner info")
+			log.WithValues("pod", name, "vault", key.String(), "status", resp.StatusCode()).
+				Error(err, "could not delete owner info")
+		}
+		metrics.PeerSyncs.WithLabelValues(metrics.PeerSyncDelete, metrics.Result(err)).Inc()
+	}
+}
+
+// peerClient returns the HTTP client for the communication with the peers.
+func (c *k8sCache) peerClient() *resty.Client {
+	once.Do(func() {
+		c.mux.Lock()
+		if c.token == "" {
+			c.token = uuid.NewString()
 		}
+		token := c.token
+		c.mux.Unlock()
+		c.client = resty.New().SetAuthToken(token)
+		c.client.SetTimeout(time.Second)
+	})
+	return c.client
+}
+
+// syncURL returns the sync url of the given cache key on the peer with the given ip.
+func syncURL(ip string, key types.VaultKey) string {
+	if constants.IsDevMode() {
+		ip = "localhost"
 	}
+	return fmt.Sprintf("http://%s/sync/%s", net.JoinHostPort(ip, strconv.Itoa(apiPort)), wireKey(key)) //nolint:revive
 }
 
 // handleAuth handles the authentication for incoming requests.
@@ -155,17 +234,26 @@ func (c *k8sCache) handleAuth(ctx *gin.Context) bool {
 	if !ok {
 		return false
 	}
-	if c.token != "" {
-		if c.token != token {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": http.StatusUnauthorized})
-			return false
-		}
-	} else {
+	c.mux.Lock()
+	if c.token == "" {
 		c.token = token
 	}
+	valid := c.token == token
+	c.mux.Unlock()
+	if !valid {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": http.StatusUnauthorized})
+		return false
+	}
 	return true
 }
 
+// authToken returns the token for the communication with the peers.
+func (c *k8sCache) authToken() string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.token
+}
+
 // getAuthToken extracts the authentication token from the request headers.
 func (*k8sCache) getAuthToken(ctx *gin.Context) (string, bool) {
 	auth := ctx.GetHeader("Authorization")
@@ -194,14 +282,14 @@ func (c *k8sCache) SetMember(members map[string]string) bool {
 
 // Sync synchronizes the cache with the peers.
 func (c *k8sCache) Sync() {
-	for _, statefulSet := range c.Vaults() {
-		c.SetVaultInfoFor(statefulSet, c.VaultInfoFor(statefulSet))
+	for _, key := range c.Vaults() {
+		c.SetVaultInfoFor(key, c.VaultInfoFor(key))
 	}
 }
 
-// vaultString returns a sorted list of stateful sets with their respective number of keys.
+// vaultString returns a sorted list of vaults with their respective number of keys.
 func (c *k8sCache) vaultString() (keys []string) {
-	for k, i := range c.vaults {
+	for k, i := range c.snapshot() {
 		keys = append(keys, fmt.Sprintf("%s (keys: %d)", k, len(i.UnsealKeys)))
 	}
 	slices.Sort(keys)
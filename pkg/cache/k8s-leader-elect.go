@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/bakito/vault-unsealer/pkg/hierarchy"
+	"github.com/bakito/vault-unsealer/pkg/metrics"
 	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
@@ -21,13 +23,32 @@ type info struct {
 	Token  string                      `json:"token"`  // Token is the authentication token for peers.
 }
 
+// peerInfoURL returns the info url of the peer with the given ip.
+var peerInfoURL = func(ip string) string {
+	return fmt.Sprintf("http://%s/info", net.JoinHostPort(ip, strconv.Itoa(apiPort))) //nolint:revive
+}
+
+// getPeers returns the peers of the instance.
+var getPeers = hierarchy.GetPeers
+
 // AskPeers requests cache information from peer nodes and updates the cache with the received information.
+// The peers are asked one after the other until one of them sends its information. An error is returned if the
+// peers can not be listed or none of them sent its information. Without peers, there is nothing to ask.
 func (c *k8sCache) AskPeers(ctx context.Context) error {
 	// Get the list of peers from the hierarchy package.
-	peers, err := hierarchy.GetPeers(ctx, c.reader, c.past132)
+	peers, err := getPeers(ctx, c.reader, c.past132)
+	if errors.Is(err, hierarchy.ErrNoEndpoints) {
+		// without endpoints, no other instance can be found
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	return c.askPeers(ctx, peers)
+}
+
+// askPeers requests the cache information from the given peers, until one of them sends it.
+func (c *k8sCache) askPeers(ctx context.Context, peers map[string]string) error {
 	if len(peers) == 0 {
 		return nil
 	}
@@ -40,18 +61,24 @@ func (c *k8sCache) AskPeers(ctx context.Context) error {
 	cl := resty.New().SetAuthToken(c.peerToken)
 	cl.SetTimeout(time.Second)
 
-	// Iterate over each peer and request cache information.
+	// Iterate over the peers and request cache information, until a peer sent it.
+	var errs []error
 	for ip, name := range peers {
 		l := log.WithValues("name", name, "ip", ip)
 		l.Info("requesting cache info from peer")
-		resp, err := cl.R().Get(fmt.Sprintf("http://%s/info", net.JoinHostPort(ip, strconv.Itoa(apiPort)))) //nolint:revive
+		resp, err := cl.R().SetContext(ctx).Get(peerInfoURL(ip))
 
 		if err != nil {
 			l.Error(err, "could request info")
 		} else if resp.StatusCode() != http.StatusOK {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode())
 			l.WithValues("status", resp.StatusCode()).Error(err, "could request info")
 		}
-		return nil // Exit after requesting info from the first peer.
+		metrics.PeerSyncs.WithLabelValues(metrics.PeerSyncAsk, metrics.Result(err)).Inc()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("peer %s (%s): %w", name, ip, err))
 	}
-	return nil
+	return errors.Join(errs...)
 }
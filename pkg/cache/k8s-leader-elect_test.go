@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/bakito/vault-unsealer/pkg/hierarchy"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AskPeers", func() {
+	var (
+		c       *k8sCache
+		ok      *httptest.Server
+		failing *httptest.Server
+		asked   atomic.Int32
+		urls    map[string]string
+	)
+
+	BeforeEach(func() {
+		c = &k8sCache{simpleCache: simpleCache{vaults: make(map[types.VaultKey]*types.VaultInfo)}}
+		asked.Store(0)
+		ok = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			asked.Add(1)
+			w.WriteHeader(http.StatusOK)
+		}))
+		failing = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		urls = map[string]string{
+			"10.0.0.1": ok.URL,
+			"10.0.0.2": failing.URL,
+			"10.0.0.3": "http://127.0.0.1:1",
+		}
+
+		orig := peerInfoURL
+		peerInfoURL = func(ip string) string { return urls[ip] + "/info" }
+		DeferCleanup(func() {
+			peerInfoURL = orig
+			ok.Close()
+			failing.Close()
+		})
+	})
+
+	It("should succeed without peers", func() {
+		Expect(c.askPeers(context.TODO(), nil)).To(Succeed())
+	})
+
+	It("should ask the next peer if a peer fails", func() {
+		for range 5 {
+			Expect(c.askPeers(context.TODO(), map[string]string{
+				"10.0.0.1": "ok",
+				"10.0.0.2": "failing",
+				"10.0.0.3": "unreachable",
+			})).To(Succeed())
+		}
+		Expect(asked.Load()).To(Equal(int32(5)))
+	})
+
+	It("should fail if no peer sent its information", func() {
+		err := c.askPeers(context.TODO(), map[string]string{
+			"10.0.0.2": "failing",
+			"10.0.0.3": "unreachable",
+		})
+		Expect(err).To(MatchError(ContainSubstring("peer failing (10.0.0.2): unexpected status 404")))
+		Expect(err).To(MatchError(ContainSubstring("peer unreachable (10.0.0.3)")))
+	})
+
+	Context("bootstrap", func() {
+		var peers map[string]string
+		var peersErr error
+
+		BeforeEach(func() {
+			peers = nil
+			peersErr = nil
+			origPeers := getPeers
+			getPeers = func(context.Context, client.Reader, bool) (map[string]string, error) { return peers, peersErr }
+			origDelay := askPeersRetryDelay
+			askPeersRetryDelay = 10 * time.Millisecond
+			DeferCleanup(func() {
+				getPeers = origPeers
+				askPeersRetryDelay = origDelay
+			})
+			elected := make(chan struct{})
+			close(elected)
+			c.electedCh = elected
+		})
+
+		It("should be bootstrapped right away without peers", func() {
+			c.bootstrap(context.TODO())
+			Expect(c.elected.Load()).To(BeTrue())
+			Expect(c.bootstrapped.Load()).To(BeTrue())
+		})
+
+		It("should be bootstrapped right away without endpoints", func() {
+			peersErr = hierarchy.ErrNoEndpoints
+			c.bootstrap(context.TODO())
+			Expect(c.bootstrapped.Load()).To(BeTrue())
+		})
+
+		It("should wait for a peer to send its information", func() {
+			peers = map[string]string{"10.0.0.2": "failing"}
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			c.bootstrap(ctx)
+			Expect(c.elected.Load()).To(BeTrue())
+			Expect(c.bootstrapped.Load()).To(BeFalse())
+		})
+	})
+
+	It("should stop bootstrapping with the manager", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		c.electedCh = make(chan struct{})
+		c.bootstrap(ctx)
+		Expect(c.elected.Load()).To(BeFalse())
+		Expect(c.bootstrapped.Load()).To(BeFalse())
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,15 +16,15 @@ import (
 	"github.com/bakito/vault-unsealer/pkg/types"
 )
 
-// webPostSync handles the POST request to synchronize cache information for a specific stateful set.
+// webPostSync handles the POST request to synchronize cache information for a specific vault.
 func (c *k8sCache) webPostSync(ctx *gin.Context) {
 	// Authenticate the request.
 	if !c.handleAuth(ctx) {
 		return
 	}
 
-	// Extract stateful set name from URL parameter.
-	statefulSet := ctx.Param("statefulSet")
+	// Extract the cache key from URL parameter.
+	raw := syncKey(ctx)
 	info := &types.VaultInfo{}
 
 	// Bind JSON payload to VaultInfo struct.
@@ -32,19 +33,53 @@ func (c *k8sCache) webPostSync(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
-		log.WithValues("from", ctx.ClientIP(), "stateful-set", statefulSet).Error(err, "could not parse owner info")
+		log.WithValues("from", ctx.ClientIP(), "vault", raw).Error(err, "could not parse owner info")
 		return
 	}
 
+	key, err := peerKey(raw, info)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.WithValues("from", ctx.ClientIP(), "vault", raw).Error(err, "could not parse vault key")
+		return
+	}
+	info.Key = key
+
 	// Update cache with received VaultInfo.
-	c.simpleCache.SetVaultInfoFor(statefulSet, info)
+	c.simpleCache.SetVaultInfoFor(key, info)
 	log.WithValues(
 		"from", ctx.ClientIP(),
-		"stateful-set", fmt.Sprintf("%s (keys: %d)", statefulSet, len(info.UnsealKeys)),
+		"vault", fmt.Sprintf("%s (keys: %d)", key, len(info.UnsealKeys)),
 	).Info("received vault info")
 	ctx.JSON(http.StatusOK, gin.H{"message": "ok"})
 }
 
+// webDeleteSync handles the DELETE request to remove the cache information of a specific vault.
+func (c *k8sCache) webDeleteSync(ctx *gin.Context) {
+	// Authenticate the request.
+	if !c.handleAuth(ctx) {
+		return
+	}
+
+	raw := syncKey(ctx)
+	key, err := peerKey(raw, nil)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.WithValues("from", ctx.ClientIP(), "vault", raw).Error(err, "could not parse vault key")
+		return
+	}
+
+	// Remove the entry from the local cache only, to not propagate the deletion back to the peers.
+	c.simpleCache.DeleteVaultInfoFor(key)
+	log.WithValues("from", ctx.ClientIP(), "vault", key.String()).Info("removed vault info")
+	ctx.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// syncKey returns the raw cache key of a sync request.
+func syncKey(ctx *gin.Context) string {
+	return strings.TrimPrefix(ctx.Param("key"), "/")
+}
+
 // webGetInfo handles the GET request to retrieve cache information.
 func (c *k8sCache) webGetInfo(ctx *gin.Context) {
 	// Log info request.
@@ -69,10 +104,11 @@ func (c *k8sCache) webGetInfo(ctx *gin.Context) {
 	}
 
 	// Send cache information to the requesting peer.
+	vaults := c.snapshot()
 	cl := resty.New().SetAuthToken(token)
 	cl.SetTimeout(time.Second)
 	resp, err := cl.R().
-		SetBody(&info{Vaults: c.vaults, Token: c.token}).
+		SetBody(&info{Vaults: toWire(vaults), Token: c.authToken()}).
 		Put(fmt.Sprintf("http://%s/info", net.JoinHostPort(ctx.ClientIP(), strconv.Itoa(apiPort)))) //nolint:revive
 
 	if err != nil {
@@ -84,7 +120,7 @@ func (c *k8sCache) webGetInfo(ctx *gin.Context) {
 		ctx.JSON(resp.StatusCode(), gin.H{"error": err.Error()})
 	}
 
-	ctx.JSON(http.StatusOK, c.vaults)
+	ctx.JSON(http.StatusOK, vaults)
 }
 
 // webPutInfo handles the PUT request to update cache information received from a peer.
@@ -111,12 +147,14 @@ func (c *k8sCache) webPutInfo(ctx *gin.Context) {
 	}
 
 	// Update cache with received information.
-	c.vaults = i.Vaults
+	c.replace(fromWire(i.Vaults))
+	c.mux.Lock()
 	c.token = i.Token
+	c.mux.Unlock()
 	if c.client != nil {
 		c.client.Token = i.Token
 	}
 	log.WithValues("from", ctx.ClientIP(), "method", ctx.Request.Method, "vaults", c.vaultString()).
 		Info("received info from peer")
-	ctx.JSON(http.StatusOK, c.vaults)
+	ctx.JSON(http.StatusOK, c.snapshot())
 }
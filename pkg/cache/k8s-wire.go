@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+)
+
+// peerKey converts a cache key received from a peer.
+// Current peers send keys in the format kind/namespace/name. Peers of older versions send the plain stateful set name,
+// optionally prefixed by the namespace. For these, the kind is derived from the VaultInfo (if available) and the
+// namespace defaults to the namespace of the unsealer.
+func peerKey(raw string, vi *types.VaultInfo) (types.VaultKey, error) {
+	if key, err := types.ParseVaultKey(raw); err == nil {
+		return key, nil
+	}
+	if vi != nil && !vi.Key.IsZero() {
+		return vi.Key, nil
+	}
+
+	namespace, name, ok := strings.Cut(raw, "/")
+	if !ok {
+		namespace, name = os.Getenv(constants.EnvNamespace), raw
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return types.VaultKey{}, fmt.Errorf("invalid vault key %q", raw)
+	}
+
+	if vi != nil && vi.StatefulSet == "" {
+		return types.ExternalKey(namespace, name), nil
+	}
+	return types.StatefulSetKey(namespace, name), nil
+}
+
+// wireKey returns the cache key sent to the peers.
+// StatefulSets in the namespace of the unsealer are sent as plain name, the format of older versions, so older peers
+// still accept them during an upgrade. Only the vaults older versions can not handle are sent as kind/namespace/name.
+func wireKey(key types.VaultKey) string {
+	if key.Kind == types.KindStatefulSet && key.Namespace == os.Getenv(constants.EnvNamespace) {
+		return key.Name
+	}
+	return key.String()
+}
+
+// toWire converts the cached vaults to the format exchanged with the peers.
+func toWire(vaults map[types.VaultKey]*types.VaultInfo) map[string]*types.VaultInfo {
+	out := make(map[string]*types.VaultInfo, len(vaults))
+	for k, v := range vaults {
+		out[wireKey(k)] = v
+	}
+	return out
+}
+
+// fromWire converts the vaults received from a peer. Invalid keys are skipped.
+func fromWire(vaults map[string]*types.VaultInfo) map[types.VaultKey]*types.VaultInfo {
+	out := make(map[types.VaultKey]*types.VaultInfo, len(vaults))
+	for raw, v := range vaults {
+		key, err := peerKey(raw, v)
+		if err != nil {
+			log.Error(err, "skipping vault info received from peer")
+			continue
+		}
+		if v != nil {
+			v.Key = key
+		}
+		out[key] = v
+	}
+	return out
+}
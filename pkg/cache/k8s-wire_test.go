@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
+	"github.com/bakito/vault-unsealer/pkg/types"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wire", func() {
+	Context("peerKey", func() {
+		It("should parse a typed key", func() {
+			key, err := peerKey("External/ns/vault", &types.VaultInfo{StatefulSet: "vault"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(types.ExternalKey("ns", "vault")))
+		})
+
+		It("should use the key of the vault info", func() {
+			key, err := peerKey("vault", &types.VaultInfo{Key: types.ExternalKey("ns", "vault")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(types.ExternalKey("ns", "vault")))
+		})
+
+		It("should convert a legacy stateful set name", func() {
+			GinkgoT().Setenv(constants.EnvNamespace, "unsealer")
+			key, err := peerKey("vault", &types.VaultInfo{StatefulSet: "vault"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(types.StatefulSetKey("unsealer", "vault")))
+		})
+
+		It("should convert a legacy external name with namespace", func() {
+			key, err := peerKey("ns/external", &types.VaultInfo{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(Equal(types.ExternalKey("ns", "external")))
+		})
+
+		It("should fail for an invalid key", func() {
+			_, err := peerKey("a/b/c/d", nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("toWire / fromWire", func() {
+		It("should convert the vaults in both directions", func() {
+			vaults := map[types.VaultKey]*types.VaultInfo{
+				types.StatefulSetKey("ns", "vault"): {StatefulSet: "vault", UnsealKeys: []string{"c", "a", "b"}},
+				types.ExternalKey("ns", "vault"):    {UnsealKeys: []string{"b"}},
+			}
+			b, err := json.Marshal(&info{Vaults: toWire(vaults)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(ContainSubstring(`"StatefulSet/ns/vault"`))
+
+			i := &info{}
+			Expect(json.Unmarshal(b, i)).To(Succeed())
+			received := fromWire(i.Vaults)
+			Expect(received).To(HaveLen(2))
+			Expect(received[types.ExternalKey("ns", "vault")].UnsealKeys).To(Equal([]string{"b"}))
+			// the order of the keys is kept, to unseal the same way on every peer
+			Expect(received[types.StatefulSetKey("ns", "vault")].UnsealKeys).To(Equal([]string{"c", "a", "b"}))
+		})
+	})
+
+	Context("legacy peers", func() {
+		var (
+			legacy   *gin.Engine
+			received []string
+		)
+
+		BeforeEach(func() {
+			GinkgoT().Setenv(constants.EnvNamespace, "unsealer")
+			gin.SetMode(gin.ReleaseMode)
+			received = nil
+			// the sync route of older versions, that only know the stateful set name
+			legacy = gin.New()
+			legacy.POST("/sync/:statefulSet", func(ctx *gin.Context) {
+				vi := &types.VaultInfo{}
+				Expect(ctx.ShouldBindJSON(vi)).To(Succeed())
+				received = append(received, ctx.Param("statefulSet"))
+				ctx.JSON(http.StatusOK, gin.H{"message": "ok"})
+			})
+		})
+
+		post := func(key types.VaultKey) int {
+			b, err := json.Marshal(&types.VaultInfo{Key: key, StatefulSet: key.Name, UnsealKeys: []string{"a"}})
+			Expect(err).NotTo(HaveOccurred())
+			rec := httptest.NewRecorder()
+			legacy.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, syncURL("10.0.0.1", key), bytes.NewReader(b)))
+			return rec.Code
+		}
+
+		It("should send stateful sets of the own namespace as plain name", func() {
+			Expect(post(types.StatefulSetKey("unsealer", "vault"))).To(Equal(http.StatusOK))
+			Expect(received).To(Equal([]string{"vault"}))
+		})
+
+		It("should send the new kinds in the new format", func() {
+			Expect(wireKey(types.StatefulSetKey("other", "vault"))).To(Equal("StatefulSet/other/vault"))
+			Expect(wireKey(types.ExternalKey("unsealer", "vault"))).To(Equal("External/unsealer/vault"))
+			Expect(post(types.ExternalKey("unsealer", "vault"))).To(Equal(http.StatusNotFound))
+			Expect(received).To(BeEmpty())
+		})
+
+		It("should exchange the legacy format with current peers", func() {
+			vaults := map[types.VaultKey]*types.VaultInfo{
+				types.StatefulSetKey("unsealer", "vault"): {StatefulSet: "vault"},
+			}
+			wire := toWire(vaults)
+			Expect(wire).To(HaveKey("vault"))
+			Expect(fromWire(wire)).To(HaveKey(types.StatefulSetKey("unsealer", "vault")))
+		})
+	})
+})
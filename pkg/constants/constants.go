@@ -15,6 +15,9 @@ const (
 	LabelExternal        = OperatorID + "/external"
 )
 
+// LabelCachePeers marks the Service whose endpoints are the peers of the shared cache.
+const LabelCachePeers = OperatorID + "/cache-peers"
+
 const (
 	AnnotationExternalSource  = LabelExternal + "-source"
 	AnnotationExternalTargets = LabelExternal + "-targets"
@@ -28,6 +31,28 @@ const ContainerNameOpenbao = "openbao"
 
 const DefaultExternalInterval = 20 * time.Minute
 
+// DefaultSealCheckInterval is the default interval of the seal check of the pods of a StatefulSet.
+const DefaultSealCheckInterval = time.Minute
+
+// DefaultLivenessTimeout is the default duration after which a reconcile or external check without progress
+// fails the liveness check.
+const DefaultLivenessTimeout = 10 * time.Minute
+
+// Defaults of the ordered unseal of the pods of a StatefulSet.
+const (
+	DefaultSettleDelay   = 10 * time.Second
+	DefaultActiveTimeout = time.Minute
+)
+
+// Default key shares and threshold of vaults initialized by the unsealer, as of 'vault operator init'.
+const (
+	DefaultInitShares    = 5
+	DefaultInitThreshold = 3
+)
+
+// AllNamespaces is the value of the watch namespaces to watch all namespaces.
+const AllNamespaces = "*"
+
 // Environment variable names.
 const (
 	envDevelopmentMode             = "UNSEALER_DEVELOPMENT_MODE"
@@ -39,6 +64,7 @@ const (
 	EnvNamespace                   = "UNSEALER_NAMESPACE"
 	EnvPodName                     = "UNSEALER_POD_NAME"
 	EnvPodIP                       = "UNSEALER_POD_IP"
+	EnvServiceAccountName          = "UNSEALER_SERVICE_ACCOUNT_NAME"
 )
 
 // Secret key names.
@@ -49,6 +75,35 @@ const (
 	KeyUsername        = "username"
 	KeyRole            = "role"
 	KeyMountPath       = "mountPath"
+	KeyAuthMethod      = "authMethod"
+	KeyRoleID          = "roleId"
+	KeySecretID        = "secretId"
+	KeyAudience        = "audience"
+	KeyTokenPath       = "tokenPath"
+	KeyTokenExpiration = "tokenExpiration"
+	KeyClientCert      = "clientCert"
+	KeyClientKey       = "clientKey"
+	KeyTransitAddress  = "transitAddress"
+	KeyTransitKey      = "transitKey"
+	KeyTransitMount    = "transitMount"
+	// KeyInitJSON holds the output of 'vault operator init -format=json'.
+	KeyInitJSON = "init.json"
+)
+
+// Vault auth methods.
+const (
+	AuthMethodUserpass   = "userpass"
+	AuthMethodKubernetes = "kubernetes"
+	AuthMethodAppRole    = "approle"
+	AuthMethodJWT        = "jwt"
+	AuthMethodCert       = "cert"
+)
+
+// Unseal order strategies of the pods of a StatefulSet.
+const (
+	UnsealOrderParallel    = "Parallel"
+	UnsealOrderOrdered     = "Ordered"
+	UnsealOrderLeaderFirst = "LeaderFirst"
 )
 
 // DevFlag returns the value of the given environment variable if development mode is enabled.
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -16,7 +17,11 @@ import (
 	"github.com/bakito/vault-unsealer/pkg/constants"
 )
 
+// ErrNoEndpoints is returned if no service endpoints of the unsealer exist.
+var ErrNoEndpoints = errors.New("could not find any service endpoints")
+
 // GetPeers returns a map of peer IPs and their associated names.
+// The endpoints of the Service labeled as cache peers are preferred, as they also contain the instances that are not ready.
 func GetPeers(ctx context.Context, r client.Reader, past132 bool) (map[string]string, error) {
 	deploymentSel, err := GetDeploymentSelector(ctx, r)
 	if err != nil {
@@ -40,22 +45,31 @@ func GetPeers(ctx context.Context, r client.Reader, past132 bool) (map[string]st
 	if past132 {
 		if epsList, ok := epl.(*discoveryv1.EndpointSliceList); ok {
 			if len(epsList.Items) == 0 {
-				return nil, errors.New("could not find any service endpoints")
+				return nil, ErrNoEndpoints
 			}
-			return GetPeersFrom(&epsList.Items[0]), nil
+			i := slices.IndexFunc(epsList.Items, func(eps discoveryv1.EndpointSlice) bool { return isCachePeers(&eps) })
+			return GetPeersFrom(&epsList.Items[max(i, 0)]), nil
 		}
 	} else {
 		if epList, ok := epl.(*corev1.EndpointsList); ok {
 			if len(epList.Items) == 0 {
-				return nil, errors.New("could not find any service endpoints")
+				return nil, ErrNoEndpoints
 			}
-			return GetPeersFrom(&epList.Items[0]), nil
+			//nolint:staticcheck // deprecation is handled
+			i := slices.IndexFunc(epList.Items, func(ep corev1.Endpoints) bool { return isCachePeers(&ep) })
+			return GetPeersFrom(&epList.Items[max(i, 0)]), nil
 		}
 	}
 
 	return nil, errors.New("invalid endpoint list type")
 }
 
+// isCachePeers returns true if the endpoints belong to the Service of the cache peers.
+func isCachePeers(obj metav1.Object) bool {
+	_, ok := obj.GetLabels()[constants.LabelCachePeers]
+	return ok
+}
+
 // GetPeersFrom extracts peer IPs and their names from the given Endpoints object.
 func GetPeersFrom(obj client.Object) map[string]string {
 	myIP := os.Getenv(constants.EnvPodIP)
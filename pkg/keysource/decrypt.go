@@ -0,0 +1,133 @@
+package keysource
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// Decrypter decrypts encrypted unseal keys.
+type Decrypter interface {
+	// Decrypt returns the plaintext unseal key of the encrypted share.
+	Decrypt(share string) (string, error)
+}
+
+// NewDecrypter returns a Decrypter for the given private key. The key type is detected from its content:
+// an age identity (AGE-SECRET-KEY-...) or an armored or binary PGP private key, protected by the optional passphrase.
+func NewDecrypter(privateKey, passphrase []byte) (Decrypter, error) {
+	if bytes.Contains(privateKey, []byte("AGE-SECRET-KEY-")) {
+		ids, err := age.ParseIdentities(bytes.NewReader(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse age identity: %w", err)
+		}
+		return &ageDecrypter{identities: ids}, nil
+	}
+
+	var keyring openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(privateKey), []byte("-----BEGIN PGP")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(privateKey))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(privateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pgp private key: %w", err)
+	}
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			return nil, errors.New("the pgp key is not a private key")
+		}
+		if err := e.DecryptPrivateKeys(passphrase); err != nil {
+			return nil, fmt.Errorf("could not decrypt pgp private key: %w", err)
+		}
+	}
+	return &pgpDecrypter{keyring: keyring}, nil
+}
+
+// pgpDecrypter decrypts shares encrypted with 'vault operator init -pgp-keys'.
+// The shares are base64 encoded binary or armored PGP messages.
+type pgpDecrypter struct {
+	keyring openpgp.EntityList
+}
+
+func (d *pgpDecrypter) Decrypt(share string) (string, error) {
+	var r io.Reader
+	share = strings.TrimSpace(share)
+	if strings.HasPrefix(share, "-----BEGIN PGP MESSAGE") {
+		block, err := pgparmor.Decode(strings.NewReader(share))
+		if err != nil {
+			return "", err
+		}
+		r = block.Body
+	} else {
+		b, err := base64.StdEncoding.DecodeString(share)
+		if err != nil {
+			return "", fmt.Errorf("pgp encrypted share is neither armored nor base64 encoded: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	md, err := openpgp.ReadMessage(r, d.keyring, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt pgp encrypted share: %w", err)
+	}
+	return readPlaintext(md.UnverifiedBody)
+}
+
+// ageDecrypter decrypts shares encrypted with age. The shares are armored, binary or base64 encoded binary files.
+type ageDecrypter struct {
+	identities []age.Identity
+}
+
+func (d *ageDecrypter) Decrypt(share string) (string, error) {
+	var r io.Reader
+	trimmed := strings.TrimSpace(share)
+	switch {
+	case strings.HasPrefix(trimmed, agearmor.Header):
+		r = agearmor.NewReader(strings.NewReader(trimmed))
+	case strings.HasPrefix(share, "age-encryption.org/"):
+		r = strings.NewReader(share)
+	default:
+		b, err := base64.StdEncoding.DecodeString(trimmed)
+		if err != nil {
+			return "", fmt.Errorf("age encrypted share is neither armored, binary nor base64 encoded: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	pr, err := age.Decrypt(r, d.identities...)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt age encrypted share: %w", err)
+	}
+	return readPlaintext(pr)
+}
+
+// readPlaintext reads the decrypted unseal key.
+func readPlaintext(r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Decrypt decrypts all given unseal keys.
+func Decrypt(d Decrypter, keys []string) ([]string, error) {
+	plain := make([]string, 0, len(keys))
+	for i, k := range keys {
+		p, err := d.Decrypt(k)
+		if err != nil {
+			return nil, fmt.Errorf("share %d: %w", i+1, err)
+		}
+		plain = append(plain, p)
+	}
+	return plain, nil
+}
@@ -0,0 +1,137 @@
+package keysource_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decrypter", func() {
+	Context("age", func() {
+		var (
+			id  *age.X25519Identity
+			sut keysource.Decrypter
+		)
+
+		BeforeEach(func() {
+			var err error
+			id, err = age.GenerateX25519Identity()
+			Ω(err).ShouldNot(HaveOccurred())
+			sut, err = keysource.NewDecrypter([]byte("# created: now\n"+id.String()+"\n"), nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		encrypt := func(w io.Writer, share string) {
+			aw, err := age.Encrypt(w, id.Recipient())
+			Ω(err).ShouldNot(HaveOccurred())
+			_, _ = io.WriteString(aw, share+"\n")
+			Ω(aw.Close()).ShouldNot(HaveOccurred())
+		}
+
+		It("should decrypt a binary share", func() {
+			buf := &bytes.Buffer{}
+			encrypt(buf, "foo")
+			Ω(sut.Decrypt(buf.String())).Should(Equal("foo"))
+			Ω(sut.Decrypt(base64.StdEncoding.EncodeToString(buf.Bytes()))).Should(Equal("foo"))
+		})
+
+		It("should decrypt an armored share", func() {
+			buf := &bytes.Buffer{}
+			w := agearmor.NewWriter(buf)
+			encrypt(w, "foo")
+			Ω(w.Close()).ShouldNot(HaveOccurred())
+			Ω(sut.Decrypt(buf.String())).Should(Equal("foo"))
+		})
+
+		It("should fail for a share encrypted for another identity", func() {
+			other, err := age.GenerateX25519Identity()
+			Ω(err).ShouldNot(HaveOccurred())
+			buf := &bytes.Buffer{}
+			aw, err := age.Encrypt(buf, other.Recipient())
+			Ω(err).ShouldNot(HaveOccurred())
+			_, _ = io.WriteString(aw, "foo")
+			Ω(aw.Close()).ShouldNot(HaveOccurred())
+			_, err = sut.Decrypt(buf.String())
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("pgp", func() {
+		var entity *openpgp.Entity
+
+		BeforeEach(func() {
+			var err error
+			entity, err = openpgp.NewEntity("unsealer", "", "unsealer@bakito.net", nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		privateKey := func(passphrase []byte) []byte {
+			if passphrase != nil {
+				Ω(entity.EncryptPrivateKeys(passphrase, nil)).ShouldNot(HaveOccurred())
+			}
+			buf := &bytes.Buffer{}
+			w, err := pgparmor.Encode(buf, openpgp.PrivateKeyType, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(entity.SerializePrivateWithoutSigning(w, nil)).ShouldNot(HaveOccurred())
+			Ω(w.Close()).ShouldNot(HaveOccurred())
+			return buf.Bytes()
+		}
+
+		// encrypt encrypts the share like 'vault operator init -pgp-keys' (base64 encoded binary message).
+		encrypt := func(share string) string {
+			buf := &bytes.Buffer{}
+			w, err := openpgp.Encrypt(buf, []*openpgp.Entity{entity}, nil, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, _ = io.WriteString(w, share)
+			Ω(w.Close()).ShouldNot(HaveOccurred())
+			return base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+
+		It("should decrypt a vault pgp encrypted share", func() {
+			share := encrypt("foo")
+			sut, err := keysource.NewDecrypter(privateKey(nil), nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(sut.Decrypt(share)).Should(Equal("foo"))
+		})
+
+		It("should decrypt with a passphrase protected key", func() {
+			share := encrypt("foo")
+			key := privateKey([]byte("secret"))
+
+			_, err := keysource.NewDecrypter(key, []byte("wrong"))
+			Ω(err).Should(HaveOccurred())
+
+			sut, err := keysource.NewDecrypter(key, []byte("secret"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(sut.Decrypt(share)).Should(Equal("foo"))
+		})
+	})
+
+	It("should decrypt all keys", func() {
+		id, err := age.GenerateX25519Identity()
+		Ω(err).ShouldNot(HaveOccurred())
+		d, err := keysource.NewDecrypter([]byte(id.String()), nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		buf := &bytes.Buffer{}
+		aw, err := age.Encrypt(buf, id.Recipient())
+		Ω(err).ShouldNot(HaveOccurred())
+		_, _ = io.WriteString(aw, "foo")
+		Ω(aw.Close()).ShouldNot(HaveOccurred())
+
+		Ω(keysource.Decrypt(d, []string{buf.String()})).Should(ConsistOf("foo"))
+
+		_, err = keysource.Decrypt(d, []string{buf.String(), "plain"})
+		Ω(err).Should(MatchError(ContainSubstring("share 2")))
+	})
+})
@@ -0,0 +1,102 @@
+package keysource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
+)
+
+// File provides the unseal keys stored in files, e.g. mounted by the Secrets Store CSI driver.
+// Path is either a directory with files named unsealKey* and/or JSON documents (*.json) with unsealKey* entries,
+// or a single JSON document.
+type File struct {
+	Path string
+}
+
+var _ KeySource = &File{}
+
+// Keys reads the unseal keys from the files.
+func (s *File) Keys(_ context.Context) ([]string, error) {
+	fi, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		named, init, err := readDocument(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		return ordered(named, init), nil
+	}
+
+	entries, err := os.ReadDir(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []namedKey
+	var init []string
+	for _, e := range entries {
+		name := e.Name()
+		// skip hidden files and the timestamped directories of kubernetes atomic writer volumes
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		p := filepath.Join(s.Path, name)
+		// follow symlinks, as mounted files usually are
+		if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name, constants.KeyPrefixUnsealKey):
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, namedKey{name: name, key: strings.TrimSpace(string(b))})
+		case strings.HasSuffix(name, ".json"):
+			named, i, err := readDocument(p)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, named...)
+			init = append(init, i...)
+		}
+	}
+	return ordered(keys, init), nil
+}
+
+// WatchDir returns the directory to watch for changes of the key files.
+func (s *File) WatchDir() string {
+	if fi, err := os.Stat(s.Path); err == nil && fi.IsDir() {
+		return s.Path
+	}
+	return filepath.Dir(s.Path)
+}
+
+func (s *File) String() string {
+	return "file " + s.Path
+}
+
+// readDocument reads the unseal key entries and the keys of the init output from the JSON document at the given path.
+func readDocument(path string) ([]namedKey, []string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make(map[string]any)
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	named, init, err := entriesOf(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return named, init, nil
+}
@@ -0,0 +1,76 @@
+package keysource_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("File", func() {
+	var (
+		ctx context.Context
+		dir string
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		dir = GinkgoT().TempDir()
+	})
+
+	write := func(name, content string) {
+		Ω(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)).ShouldNot(HaveOccurred())
+	}
+
+	It("should read the unseal key files of a directory", func() {
+		write("unsealKey1", "foo\n")
+		write("unsealKey2", "bar")
+		write("other", "value")
+		write(".unsealKey3", "hidden")
+		Ω(os.Mkdir(filepath.Join(dir, "unsealKey4"), 0o700)).ShouldNot(HaveOccurred())
+
+		src := &keysource.File{Path: dir}
+		Ω(src.Keys(ctx)).Should(ConsistOf("foo", "bar"))
+		Ω(src.WatchDir()).Should(Equal(dir))
+	})
+
+	It("should read the JSON documents of a directory", func() {
+		write("keys.json", `{"unsealKey1": "foo", "unsealKey2": "bar", "other": "value"}`)
+		Ω((&keysource.File{Path: dir}).Keys(ctx)).Should(ConsistOf("foo", "bar"))
+	})
+
+	It("should order the keys of files and documents by their number", func() {
+		write("unsealKey11", "k11")
+		write("unsealKey3", "k3")
+		write("keys.json", `{"unsealKey1": "k1", "unsealKey2": "k2", "unsealKey4": "k3"}`)
+		Ω((&keysource.File{Path: dir}).Keys(ctx)).Should(Equal([]string{"k1", "k2", "k3", "k11"}))
+	})
+
+	It("should read the init output of a directory", func() {
+		write("unsealKey1", "foo")
+		write("init.json", `{"unseal_keys_b64": ["bar", "foo"], "root_token": "hvs.root"}`)
+		Ω((&keysource.File{Path: dir}).Keys(ctx)).Should(Equal([]string{"foo", "bar"}))
+	})
+
+	It("should read a single JSON document", func() {
+		write("keys.json", `{"unsealKey1": "foo"}`)
+		src := &keysource.File{Path: filepath.Join(dir, "keys.json")}
+		Ω(src.Keys(ctx)).Should(ConsistOf("foo"))
+		Ω(src.WatchDir()).Should(Equal(dir))
+	})
+
+	It("should fail for an invalid JSON document", func() {
+		write("keys.json", `{`)
+		_, err := (&keysource.File{Path: dir}).Keys(ctx)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should fail if the path does not exist", func() {
+		_, err := (&keysource.File{Path: filepath.Join(dir, "missing")}).Keys(ctx)
+		Ω(err).Should(HaveOccurred())
+	})
+})
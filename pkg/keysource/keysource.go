@@ -0,0 +1,188 @@
+package keysource
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
+)
+
+// Fields of the output of 'vault operator init -format=json'.
+const (
+	initFieldKeysB64 = "unseal_keys_b64"
+	initFieldKeysHex = "unseal_keys_hex"
+)
+
+// InitOutput is the output of 'vault operator init -format=json'.
+type InitOutput struct {
+	UnsealKeysB64   []string `json:"unseal_keys_b64"`
+	UnsealKeysHex   []string `json:"unseal_keys_hex"`
+	UnsealShares    int32    `json:"unseal_shares"`
+	UnsealThreshold int32    `json:"unseal_threshold"`
+	RootToken       string   `json:"root_token,omitempty"`
+}
+
+// KeySource provides the unseal keys of a vault.
+type KeySource interface {
+	// Keys returns the unseal keys provided by the source.
+	Keys(ctx context.Context) ([]string, error)
+	// String describes the source, it must not contain any key material.
+	String() string
+}
+
+// Secret provides the unseal keys stored in the data of a Kubernetes Secret.
+type Secret struct {
+	Namespace string
+	Name      string
+	Data      map[string][]byte
+}
+
+var _ KeySource = &Secret{}
+
+// Keys returns the values of all entries with the unseal key prefix and the keys of an embedded init output.
+func (s *Secret) Keys(_ context.Context) ([]string, error) {
+	data := make(map[string]any, len(s.Data))
+	for k, v := range s.Data {
+		data[k] = string(v)
+	}
+	return keysOf(data)
+}
+
+func (s *Secret) String() string {
+	return fmt.Sprintf("secret %s/%s", s.Namespace, s.Name)
+}
+
+// keysOf returns the unseal keys of the document: the values of all entries with the unseal key prefix,
+// followed by the keys of an embedded init output.
+func keysOf(data map[string]any) ([]string, error) {
+	named, init, err := entriesOf(data)
+	if err != nil {
+		return nil, err
+	}
+	return ordered(named, init), nil
+}
+
+// entriesOf returns the entries with the unseal key prefix and the keys of the init output of the document.
+// The init output is either the document itself or embedded as JSON in the init.json entry.
+func entriesOf(data map[string]any) ([]namedKey, []string, error) {
+	var named []namedKey
+	for k, v := range data {
+		if strings.HasPrefix(k, constants.KeyPrefixUnsealKey) {
+			named = append(named, namedKey{name: k, key: fmt.Sprintf("%v", v)})
+		}
+	}
+
+	init := initKeysOf(data)
+	if raw, ok := data[constants.KeyInitJSON]; ok {
+		embedded, err := parseInitOutput(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse %s: %w", constants.KeyInitJSON, err)
+		}
+		init = append(init, embedded...)
+	}
+	return named, init, nil
+}
+
+// parseInitOutput parses the init output, given as JSON string or already parsed document.
+func parseInitOutput(raw any) ([]string, error) {
+	var doc map[string]any
+	switch v := raw.(type) {
+	case map[string]any:
+		doc = v
+	case string:
+		if err := json.Unmarshal([]byte(v), &doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected type %T", raw)
+	}
+	keys := initKeysOf(doc)
+	if len(keys) == 0 {
+		return nil, errors.New("the init output contains no unseal keys")
+	}
+	return keys, nil
+}
+
+// initKeysOf returns the unseal keys of the output of 'vault operator init -format=json'.
+// The base64 encoded keys are preferred, the hex encoded keys are the same keys. The root token is ignored.
+func initKeysOf(doc map[string]any) []string {
+	for _, field := range []string{initFieldKeysB64, initFieldKeysHex} {
+		values, ok := doc[field].([]any)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(values))
+		for _, v := range values {
+			keys = append(keys, fmt.Sprintf("%v", v))
+		}
+		return keys
+	}
+	return nil
+}
+
+// namedKey is an unseal key with the name of the entry it is stored in.
+type namedKey struct {
+	name string
+	key  string
+}
+
+// ordered returns the keys ordered by the numeric suffix of their names (unsealKey1, unsealKey2, ..., unsealKey10).
+// Names without numeric suffix are ordered by name after them, followed by the keys of the init output.
+// The keys are normalized and duplicate keys are removed.
+func ordered(keys []namedKey, init []string) []string {
+	slices.SortStableFunc(keys, func(a, b namedKey) int {
+		na, errA := strconv.Atoi(strings.TrimPrefix(a.name, constants.KeyPrefixUnsealKey))
+		nb, errB := strconv.Atoi(strings.TrimPrefix(b.name, constants.KeyPrefixUnsealKey))
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			return cmp.Compare(na, nb)
+		case errA == nil && errB != nil:
+			return -1
+		case errA != nil && errB == nil:
+			return 1
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+
+	plain := make([]string, 0, len(keys)+len(init))
+	for _, k := range keys {
+		plain = append(plain, Normalize(k.key))
+	}
+	for _, k := range init {
+		plain = append(plain, Normalize(k))
+	}
+	return Unique(plain)
+}
+
+// Normalize returns hex encoded keys base64 encoded, so the same key is used only once, no matter how it is encoded.
+// Vault accepts both encodings and tries hex first, other keys (e.g. encrypted keys) are returned as they are.
+func Normalize(key string) string {
+	if b, err := hex.DecodeString(key); err == nil && len(b) > 0 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return key
+}
+
+// Unique removes duplicate keys, the first occurrence of a key is kept.
+func Unique(keys []string) []string {
+	if keys == nil {
+		return nil
+	}
+	seen := make(map[string]bool, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			unique = append(unique, k)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,13 @@
+package keysource_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestKeySource(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "KeySource Suite")
+}
@@ -0,0 +1,196 @@
+package keysource_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/hashicorp/vault-client-go"
+
+	"github.com/bakito/vault-unsealer/pkg/keysource"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeySource", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+	})
+
+	Context("Secret", func() {
+		It("should return the unseal keys of the secret", func() {
+			src := &keysource.Secret{Namespace: "default", Name: "unseal", Data: map[string][]byte{
+				"unsealKey1": []byte("foo"),
+				"unsealKey2": []byte("bar"),
+				"username":   []byte("user"),
+			}}
+			Ω(src.Keys(ctx)).Should(ConsistOf("foo", "bar"))
+			Ω(src.String()).Should(Equal("secret default/unseal"))
+		})
+
+		It("should order the keys by their number and remove duplicates", func() {
+			src := &keysource.Secret{Data: map[string][]byte{
+				"unsealKey10":    []byte("k10"),
+				"unsealKey2":     []byte("k2"),
+				"unsealKey1":     []byte("k1"),
+				"unsealKeyExtra": []byte("extra"),
+				"unsealKey3":     []byte("k1"),
+				"unsealKey":      []byte("k0"),
+			}}
+			for range 10 {
+				Ω(src.Keys(ctx)).Should(Equal([]string{"k1", "k2", "k10", "k0", "extra"}))
+			}
+		})
+	})
+
+	Context("init output", func() {
+		const initJSON = `{
+  "unseal_keys_b64": ["q83vAQ==", "AQIDBA=="],
+  "unseal_keys_hex": ["abcdef01", "01020304"],
+  "unseal_shares": 2,
+  "unseal_threshold": 2,
+  "root_token": "hvs.root"
+}`
+
+		It("should read the base64 keys of the init output of a secret", func() {
+			src := &keysource.Secret{Data: map[string][]byte{"init.json": []byte(initJSON)}}
+			Ω(src.Keys(ctx)).Should(Equal([]string{"q83vAQ==", "AQIDBA=="}))
+		})
+
+		It("should use the hex keys if there are no base64 keys", func() {
+			src := &keysource.Secret{Data: map[string][]byte{
+				"init.json": []byte(`{"unseal_keys_hex": ["abcdef01", "01020304"], "root_token": "hvs.root"}`),
+			}}
+			Ω(src.Keys(ctx)).Should(Equal([]string{"q83vAQ==", "AQIDBA=="}))
+		})
+
+		It("should remove keys provided in both encodings", func() {
+			src := &keysource.Secret{Data: map[string][]byte{
+				"unsealKey1": []byte("abcdef01"),
+				"init.json":  []byte(initJSON),
+			}}
+			Ω(src.Keys(ctx)).Should(Equal([]string{"q83vAQ==", "AQIDBA=="}))
+		})
+
+		It("should fail for invalid init output", func() {
+			_, err := (&keysource.Secret{Data: map[string][]byte{"init.json": []byte("{")}}).Keys(ctx)
+			Ω(err).Should(MatchError(ContainSubstring("could not parse init.json")))
+
+			_, err = (&keysource.Secret{Data: map[string][]byte{"init.json": []byte(`{"root_token": "x"}`)}}).Keys(ctx)
+			Ω(err).Should(MatchError(ContainSubstring("contains no unseal keys")))
+		})
+	})
+
+	Context("Unique", func() {
+		It("should keep the first occurrence of a key", func() {
+			Ω(keysource.Unique([]string{"b", "a", "b", "c", "a"})).Should(Equal([]string{"b", "a", "c"}))
+			Ω(keysource.Unique(nil)).Should(BeNil())
+		})
+	})
+
+	Context("Normalize", func() {
+		It("should encode hex keys base64", func() {
+			Ω(keysource.Normalize("666f6f")).Should(Equal("Zm9v"))
+			Ω(keysource.Normalize("Zm9v")).Should(Equal("Zm9v"))
+		})
+	})
+
+	Context("VaultKV", func() {
+		var (
+			srv     *httptest.Server
+			version string
+			secret  map[string]any
+			written map[string]map[string]any
+		)
+
+		BeforeEach(func() {
+			secret = map[string]any{"unsealKey1": "foo", "unsealKey2": "bar", "other": "value"}
+			written = map[string]map[string]any{}
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost || r.Method == http.MethodPut {
+					var req map[string]any
+					_ = json.NewDecoder(r.Body).Decode(&req)
+					written[r.URL.Path] = req
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				var body map[string]any
+				switch r.URL.Path {
+				case "/v1/sys/mounts":
+					body = map[string]any{"data": map[string]any{
+						"kv/": map[string]any{"options": map[string]any{"version": version}},
+					}}
+				case "/v1/kv/unseal":
+					body = map[string]any{"data": secret}
+				case "/v1/kv/data/unseal":
+					body = map[string]any{"data": map[string]any{"data": secret}}
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(body)
+			}))
+		})
+
+		AfterEach(func() {
+			srv.Close()
+		})
+
+		kv := func() *keysource.VaultKV {
+			cl, err := vault.New(vault.WithAddress(srv.URL))
+			Ω(err).ShouldNot(HaveOccurred())
+			return &keysource.VaultKV{Client: cl, Mount: "kv", Path: "unseal"}
+		}
+
+		read := func() ([]string, error) {
+			return kv().Keys(ctx)
+		}
+
+		It("should read the unseal keys from kv v1", func() {
+			version = "1"
+			Ω(read()).Should(ConsistOf("foo", "bar"))
+		})
+
+		It("should read the unseal keys from kv v2", func() {
+			version = "2"
+			Ω(read()).Should(ConsistOf("foo", "bar"))
+		})
+
+		It("should read the unseal keys of an init output stored as kv secret", func() {
+			version = "2"
+			secret = map[string]any{"unseal_keys_b64": []string{"foo", "bar"}, "root_token": "hvs.root"}
+			Ω(read()).Should(Equal([]string{"foo", "bar"}))
+		})
+
+		It("should read the unseal keys of an init output stored in a kv field", func() {
+			version = "1"
+			secret = map[string]any{"init.json": `{"unseal_keys_b64": ["foo", "bar"]}`}
+			Ω(read()).Should(Equal([]string{"foo", "bar"}))
+		})
+
+		It("should fail for an unknown kv version", func() {
+			version = ""
+			_, err := read()
+			Ω(err).Should(HaveOccurred())
+			Ω(kv().Store(ctx, map[string]any{"unsealKey1": "foo"})).ShouldNot(Succeed())
+		})
+
+		It("should store the data in kv v1", func() {
+			version = "1"
+			Ω(kv().Store(ctx, map[string]any{"unsealKey1": "foo"})).Should(Succeed())
+			Ω(written).Should(HaveKeyWithValue("/v1/kv/unseal", map[string]any{"unsealKey1": "foo"}))
+		})
+
+		It("should store the data in kv v2", func() {
+			version = "2"
+			Ω(kv().Store(ctx, map[string]any{"unsealKey1": "foo"})).Should(Succeed())
+			Ω(written).Should(HaveKeyWithValue("/v1/kv/data/unseal",
+				map[string]any{"data": map[string]any{"unsealKey1": "foo"}}))
+		})
+	})
+})
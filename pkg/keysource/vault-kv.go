@@ -0,0 +1,107 @@
+package keysource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// VaultKV provides the unseal keys stored in a secret of a vault kv secrets engine.
+// The kv version (1 or 2) is detected from the mount options. The client must be authenticated.
+type VaultKV struct {
+	Client *vault.Client
+	Mount  string
+	Path   string
+}
+
+var _ KeySource = &VaultKV{}
+
+// Keys reads the secret and returns the values of all entries with the unseal key prefix.
+func (s *VaultKV) Keys(ctx context.Context) ([]string, error) {
+	version, err := s.version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]any
+	var warnings []string
+
+	switch version {
+	case "1":
+		sec, err := s.Client.Secrets.KvV1Read(ctx, s.Path, vault.WithMountPath(s.Mount))
+		if err != nil {
+			return nil, err
+		}
+		data = sec.Data
+		warnings = sec.Warnings
+	case "2":
+		sec, err := s.Client.Secrets.KvV2Read(ctx, s.Path, vault.WithMountPath(s.Mount))
+		if err != nil {
+			return nil, err
+		}
+		data = sec.Data.Data
+		warnings = sec.Warnings
+	}
+
+	if data == nil {
+		return nil, fmt.Errorf("did not receive a valid secret with path %s/%s", s.Mount, s.Path)
+	}
+
+	if len(warnings) > 0 {
+		return nil, errors.New(strings.Join(warnings, ","))
+	}
+
+	return keysOf(data)
+}
+
+// Store writes the data to the secret. With kv version 2 a new version of the secret is created.
+func (s *VaultKV) Store(ctx context.Context, data map[string]any) error {
+	version, err := s.version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version == "1" {
+		_, err = s.Client.Secrets.KvV1Write(ctx, s.Path, data, vault.WithMountPath(s.Mount))
+	} else {
+		_, err = s.Client.Secrets.KvV2Write(ctx, s.Path, schema.KvV2WriteRequest{Data: data}, vault.WithMountPath(s.Mount))
+	}
+	return err
+}
+
+// version returns the version of the kv secrets engine, detected from the mount options.
+func (s *VaultKV) version(ctx context.Context) (string, error) {
+	mounts, err := s.Client.System.MountsListSecretsEngines(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	version := childOf[string](mounts.Data, s.Mount+"/", "options", "version")
+	if version != "1" && version != "2" {
+		return "", fmt.Errorf("unsupported kv version %q", version)
+	}
+	return version, nil
+}
+
+func (s *VaultKV) String() string {
+	return fmt.Sprintf("vault kv %s/%s", s.Mount, s.Path)
+}
+
+// childOf retrieves a nested value from a map[string]any.
+func childOf[T any](m any, key ...string) T {
+	var empty T
+	if mm, ok := m.(map[string]any); ok {
+		if len(key) == 1 {
+			if t, ok := mm[key[0]].(T); ok {
+				return t
+			}
+			return empty
+		}
+		return childOf[T](mm[key[0]], key[1:]...)
+	}
+	return empty
+}
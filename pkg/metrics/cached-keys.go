@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var cachedUnsealKeysDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "cached_unseal_keys"),
+	"Number of unseal keys cached for the vault.",
+	[]string{"vault"}, nil,
+)
+
+// cachedUnsealKeys reports the number of cached unseal keys per vault. The counts are read on every scrape,
+// so removed vaults are not reported anymore. The keys themselves are never exposed.
+type cachedUnsealKeys struct {
+	counts func() map[string]int
+}
+
+func (*cachedUnsealKeys) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cachedUnsealKeysDesc
+}
+
+func (c *cachedUnsealKeys) Collect(ch chan<- prometheus.Metric) {
+	for vault, n := range c.counts() {
+		ch <- prometheus.MustNewConstMetric(cachedUnsealKeysDesc, prometheus.GaugeValue, float64(n), vault)
+	}
+}
+
+// RegisterCachedUnsealKeys registers the gauge of the cached unseal keys, counted per vault by the given function.
+func RegisterCachedUnsealKeys(counts func() map[string]int) error {
+	return metrics.Registry.Register(&cachedUnsealKeys{counts: counts})
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "vault_unsealer"
+
+// Results of an operation.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Vault token operations.
+const (
+	TokenLogin  = "login"
+	TokenRenew  = "renew"
+	TokenRevoke = "revoke"
+)
+
+// Reasons of failed unseals.
+const (
+	ReasonInsufficientKeys = "insufficient_keys"
+	ReasonKeysRejected     = "keys_rejected"
+	ReasonDecrypt          = "decrypt"
+	ReasonVaultError       = "vault_error"
+	ReasonUnreachable      = "unreachable"
+)
+
+// Shared cache peer sync operations.
+const (
+	PeerSyncSet    = "set"
+	PeerSyncDelete = "delete"
+	PeerSyncAsk    = "ask"
+)
+
+var (
+	// VaultTokenOperations counts the vault token operations by operation and result.
+	VaultTokenOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vault_token_operations_total",
+		Help:      "Number of vault token logins, renewals and revocations.",
+	}, []string{"operation", "result"})
+
+	// VaultTokenAge is the age of the vault tokens kept for renewal.
+	VaultTokenAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "vault_token_age_seconds",
+		Help:      "Age of the vault token kept for a vault.",
+	}, []string{"vault"})
+
+	// UnsealThreshold is the number of unseal keys required to unseal a vault.
+	UnsealThreshold = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unseal_threshold",
+		Help:      "Number of unseal keys required to unseal the vault.",
+	}, []string{"target"})
+
+	// UnsealProgress is the number of unseal keys accepted by a sealed vault.
+	UnsealProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "unseal_progress",
+		Help:      "Number of unseal keys accepted by the sealed vault.",
+	}, []string{"target"})
+
+	// UnsealKeysSubmitted counts the unseal keys submitted to vaults by result.
+	UnsealKeysSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_keys_submitted_total",
+		Help:      "Number of unseal keys submitted to vaults.",
+	}, []string{"result"})
+
+	// Sealed reports whether a vault is sealed.
+	Sealed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sealed",
+		Help:      "Whether the vault is sealed (1) or unsealed (0).",
+	}, []string{"target"})
+
+	// UnsealAttempts counts the unseals of vaults by result.
+	UnsealAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_attempts_total",
+		Help:      "Number of attempts to unseal a vault.",
+	}, []string{"result"})
+
+	// UnsealFailures counts the failed unseals of vaults by reason.
+	UnsealFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unseal_failures_total",
+		Help:      "Number of failed attempts to unseal a vault.",
+	}, []string{"reason"})
+
+	// TimeToUnseal observes the time from the start of a vault pod until it is unsealed.
+	TimeToUnseal = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_unseal_seconds",
+		Help:      "Time from the start of the vault container until the vault is unsealed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"vault"})
+
+	// VaultAPIDuration observes the latency of vault api requests.
+	VaultAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vault_api_request_duration_seconds",
+		Help:      "Latency of the requests to the vault api.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "code"})
+
+	// PeerSyncs counts the synchronizations of the shared cache with its peers by operation and result.
+	PeerSyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "peer_sync_total",
+		Help:      "Number of synchronizations of the shared cache with its peers.",
+	}, []string{"operation", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		VaultTokenOperations,
+		VaultTokenAge,
+		UnsealThreshold,
+		UnsealProgress,
+		UnsealKeysSubmitted,
+		Sealed,
+		UnsealAttempts,
+		UnsealFailures,
+		TimeToUnseal,
+		VaultAPIDuration,
+		PeerSyncs,
+	)
+}
+
+// Result returns the result label of an operation with the given error.
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// Bool returns the gauge value of the given flag.
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstrumentVaultAPI returns a round tripper observing the latency of the vault api requests sent with the given one.
+func InstrumentVaultAPI(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		VaultAPIDuration.WithLabelValues(operationOf(req.URL.Path), code).Observe(time.Since(start).Seconds())
+		return resp, err
+	})
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// operationOf returns the operation label of the api path. System paths are kept (e.g. sys/seal-status),
+// the paths of auth methods and secret engines are reduced to 'auth' and 'secret', as their mounts are configurable.
+func operationOf(path string) string {
+	path = strings.TrimPrefix(path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "sys/"):
+		return path
+	case strings.HasPrefix(path, "auth/"):
+		return "auth"
+	default:
+		return "secret"
+	}
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VaultKind is the kind of vault a VaultInfo belongs to.
+type VaultKind string
+
+const (
+	// KindStatefulSet identifies vaults running as StatefulSet in the cluster.
+	KindStatefulSet VaultKind = "StatefulSet"
+	// KindExternal identifies external vaults.
+	KindExternal VaultKind = "External"
+)
+
+// VaultKey identifies a vault in the cache.
+// It is serialized in the format kind/namespace/name.
+type VaultKey struct {
+	Kind      VaultKind
+	Namespace string
+	Name      string
+}
+
+// StatefulSetKey returns the key of the vault StatefulSet with the given name.
+func StatefulSetKey(namespace, name string) VaultKey {
+	return VaultKey{Kind: KindStatefulSet, Namespace: namespace, Name: name}
+}
+
+// ExternalKey returns the key of the external vault configuration with the given name.
+func ExternalKey(namespace, name string) VaultKey {
+	return VaultKey{Kind: KindExternal, Namespace: namespace, Name: name}
+}
+
+// ParseVaultKey parses a key in the format kind/namespace/name.
+func ParseVaultKey(s string) (VaultKey, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return VaultKey{}, fmt.Errorf("invalid vault key %q", s)
+	}
+	k := VaultKey{Kind: VaultKind(parts[0]), Namespace: parts[1], Name: parts[2]}
+	if k.Kind != KindStatefulSet && k.Kind != KindExternal {
+		return VaultKey{}, fmt.Errorf("invalid kind %q of vault key %q", parts[0], s)
+	}
+	return k, nil
+}
+
+// String returns the key in the format kind/namespace/name.
+func (k VaultKey) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.Kind, k.Namespace, k.Name)
+}
+
+// IsZero returns true if the key is not set.
+func (k VaultKey) IsZero() bool {
+	return k == VaultKey{}
+}
+
+// MarshalText implements encoding.TextMarshaler, to allow the key to be used as JSON map key.
+func (k VaultKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *VaultKey) UnmarshalText(text []byte) error {
+	parsed, err := ParseVaultKey(string(text))
+	if err != nil {
+		return err
+	}
+	*k = parsed
+	return nil
+}
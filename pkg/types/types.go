@@ -1,13 +1,18 @@
 package types
 
 import (
+	"slices"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/json"
+
+	"github.com/bakito/vault-unsealer/pkg/constants"
 )
 
 // VaultInfo represents the configuration data for a Vault instance.
 type VaultInfo struct {
+	Key         VaultKey `json:"key,omitzero"`
 	StatefulSet string   `json:"statefulSet"`
 	Username    string   `json:"username,omitempty"`
 	Password    string   `json:"password,omitempty"`
@@ -15,6 +20,51 @@ type VaultInfo struct {
 	SecretPath  string   `json:"secretPath,omitempty"`
 	Role        string   `json:"role,omitempty"`
 	MountPath   string   `json:"mountPath,omitempty"`
+	AuthMethod  string   `json:"authMethod,omitempty"`
+	RoleID      string   `json:"roleId,omitempty"`
+	SecretID    string   `json:"secretId,omitempty"`
+	// service account token settings for kubernetes and jwt auth
+	Audience        string        `json:"audience,omitempty"`
+	TokenPath       string        `json:"tokenPath,omitempty"`
+	TokenExpiration time.Duration `json:"tokenExpiration,omitempty"`
+	// KeyPath is the path of the key files the unseal keys are read from.
+	KeyPath string `json:"keyPath,omitempty"`
+	// PEM encoded client certificate and key for cert auth
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// transit vault to decrypt transit encrypted unseal keys with
+	TransitAddress string `json:"transitAddress,omitempty"`
+	TransitKey     string `json:"transitKey,omitempty"`
+	TransitMount   string `json:"transitMount,omitempty"`
+	// Init initializes the vault, if it is not initialized yet.
+	Init *InitConfig `json:"init,omitempty"`
+	// RaftJoin joins uninitialized raft nodes to the raft cluster of the active node.
+	RaftJoin *RaftJoinConfig `json:"raftJoin,omitempty"`
+	// ordered unseal of the pods of the StatefulSet
+	UnsealOrder   string        `json:"unsealOrder,omitempty"`
+	SettleDelay   time.Duration `json:"settleDelay,omitempty"`
+	ActiveTimeout time.Duration `json:"activeTimeout,omitempty"`
+	// SealCheckInterval is the interval in which the seal status of the pods of the StatefulSet is checked.
+	SealCheckInterval time.Duration `json:"sealCheckInterval,omitempty"`
+}
+
+// RaftJoinConfig defines how the active node is verified by joining raft nodes.
+type RaftJoinConfig struct {
+	LeaderCACert        string `json:"leaderCACert,omitempty"`
+	LeaderTLSServerName string `json:"leaderTLSServerName,omitempty"`
+}
+
+// InitConfig defines the initialization of a vault and where its init output is stored.
+type InitConfig struct {
+	Shares    int32    `json:"shares"`
+	Threshold int32    `json:"threshold"`
+	PGPKeys   []string `json:"pgpKeys,omitempty"`
+	// SecretName is the name of the Secret in the namespace of the vault the init output is stored in.
+	SecretName string `json:"secretName,omitempty"`
+	// VaultAddress and VaultPath define the kv secret of another vault the init output is stored in.
+	VaultAddress   string `json:"vaultAddress,omitempty"`
+	VaultPath      string `json:"vaultPath,omitempty"`
+	StoreRootToken bool   `json:"storeRootToken,omitempty"`
 }
 
 // ShouldShare returns true if the Vault instance should share its unseal keys.
@@ -22,6 +72,39 @@ func (i *VaultInfo) ShouldShare() bool {
 	return len(i.UnsealKeys) > 0
 }
 
+// Clone returns a copy of the VaultInfo, that can be changed without affecting the cached VaultInfo.
+func (i *VaultInfo) Clone() *VaultInfo {
+	c := *i
+	c.UnsealKeys = slices.Clone(i.UnsealKeys)
+	return &c
+}
+
+// Ordered returns true if the pods of the StatefulSet are unsealed one at a time.
+func (i *VaultInfo) Ordered() bool {
+	return i.UnsealOrder == constants.UnsealOrderOrdered || i.UnsealOrder == constants.UnsealOrderLeaderFirst
+}
+
+// LoginMethod returns the auth method to be used for the vault login.
+// If no method is configured explicitly, it is derived from the available credentials.
+func (i *VaultInfo) LoginMethod() string {
+	if i.AuthMethod != "" {
+		return i.AuthMethod
+	}
+	if i.Username != "" && i.Password != "" {
+		return constants.AuthMethodUserpass
+	}
+	if i.RoleID != "" {
+		return constants.AuthMethodAppRole
+	}
+	if i.ClientCert != "" && i.ClientKey != "" {
+		return constants.AuthMethodCert
+	}
+	if strings.TrimSpace(i.Role) != "" {
+		return constants.AuthMethodKubernetes
+	}
+	return ""
+}
+
 // JSON returns the JSON representation of the VaultInfo struct.
 func (i *VaultInfo) JSON() ([]byte, error) {
 	return json.Marshal(i)